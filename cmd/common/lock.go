@@ -0,0 +1,25 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Project file locking shared by the reid commands
+ */
+package common
+
+import (
+	"fmt"
+	"os"
+
+	"../../reid"
+)
+
+// Lock a project file for exclusive (modifying) or shared (read-only) use,
+// exiting if another reid process is using it. The lock is held until exit.
+func LockProject(filename string, exclusive bool) *reid.ProjectLock {
+	lock, err := reid.LockProject(filename, exclusive)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(4)
+	}
+	return lock
+}
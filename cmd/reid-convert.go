@@ -9,6 +9,15 @@
  * used to convert a specific set of records, or to force records to be
  * reconverted.
  *
+ * The --stale flag reconverts only entries whose PDFs have changed since they
+ * were converted.
+ *
+ * The --tag and --exclude-tag flags limit the entries operated upon to those
+ * with (or without) the specified tags. See reid-project tag.
+ *
+ * The --enrich and --accept flags may be used to fill in missing record
+ * metadata (e.g., the year or publication) based upon the contents of PDFs.
+ *
  * Run with --help for usage information.
  */
 package main
@@ -24,6 +33,7 @@ import (
 )
 
 var records []reid.RecordToConvert
+var tagFilter reid.TagFilter
 
 // Command-line configuration items
 var (
@@ -84,12 +94,50 @@ var (
 
 	hashes = kingpin.
 		Flag("hash",
-			"Specify a project file entry to convert, by metadata hash. "+
+			"Specify a project file entry to convert, by identifier. "+
+				"Former identifiers (e.g., metadata hashes) may also be used. "+
 				"Multiple hashes may be specified. May be used in "+
 				"conjunction with other entry specifier flags.").
 		Short('H').
 		Strings()
 
+	tags = kingpin.
+		Flag("tag",
+			"Only convert entries with the specified tag. If specified "+
+				"multiple times, entries with any of the tags are converted.").
+		Strings()
+
+	excludeTags = kingpin.
+			Flag("exclude-tag",
+			"Do not convert entries with the specified tag. May be "+
+				"specified multiple times.").
+		Strings()
+
+	enrich = kingpin.
+		Flag("enrich",
+			"Rather than converting PDFs, propose values for the empty DOI, "+
+				"Year, Publication, and Authors fields of records, based upon "+
+				"the text of the first pages of their PDFs and the PDFs' "+
+				"document information. Proposals are saved to the project "+
+				"file and displayed.").
+		Bool()
+
+	accept = kingpin.
+		Flag("accept",
+			"Accept the first proposed value for each empty field of the "+
+				"specified entries, as previously determined using --enrich.").
+		Bool()
+
+	stale = kingpin.
+		Flag("stale",
+			"Reconvert only those of the specified entries (or all "+
+				"entries) whose PDFs have changed since they were converted.").
+		Bool()
+
+	showProposals = kingpin.
+			Flag("proposals", "Display previously proposed values and exit.").
+			Bool()
+
 	debug   = kingpin.Flag(c.FLAG_DEBUG, c.FLAG_DEBUG_DESC).Bool()
 	verbose = kingpin.Flag(c.FLAG_VERBOSE, c.FLAG_VERBOSE_DESC).Bool()
 	version = kingpin.Flag(c.FLAG_VERSION, c.FLAG_VERSION_DESC).Bool()
@@ -124,15 +172,57 @@ func main() {
 		records = append(records, reid.RecordToConvert{Hash: hash})
 	}
 
-	project, err := reid.LoadProject(*projectFile)
+	// Displaying proposals does not modify the project
+	exclusive := !*showProposals
+	c.LockProject(*projectFile, exclusive)
+
+	project, err := reid.LoadProject(*projectFile, exclusive)
 	if err != nil {
 		fmt.Fprint(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	err = project.Convert(records, *ocr, *force)
+	tagFilter = reid.TagFilter{Include: *tags, Exclude: *excludeTags}
+	project.RestrictToTags(tagFilter)
+
+	switch {
+	case *showProposals:
+		printProposals(project)
+
+	case *enrich:
+		err = project.Enrich(records, *ocr)
+		printProposals(project)
+
+	case *accept:
+		var n int
+		n, err = project.AcceptProposals(records)
+		fmt.Printf("Updated %d entries.\n", n)
+
+	case *stale:
+		var n int
+		n, err = project.ConvertStale(records, *ocr)
+		fmt.Printf("Reconverted %d stale entries.\n", n)
+
+	default:
+		err = project.Convert(records, *ocr, *force)
+	}
+
 	if err != nil {
 		fmt.Fprint(os.Stderr, err)
 		os.Exit(2)
 	}
 }
+
+func printProposals(project *reid.Project) {
+	for _, entry := range project.Entries {
+		if len(entry.Proposals) == 0 || !tagFilter.Matches(&entry) {
+			continue
+		}
+
+		fmt.Printf("%s \"%s\"\n", entry.Hash, entry.Record.Title)
+		for _, proposal := range entry.Proposals {
+			fmt.Printf("   %s\n", proposal.String())
+		}
+		fmt.Println()
+	}
+}
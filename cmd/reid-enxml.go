@@ -2,16 +2,20 @@
  * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
  * SPDX License Identifier: GPL-3.0
  *
- * reid-enxml: Load and extract data from EndNote XML files
+ * reid-enxml: Load and extract data from EndNote XML files or libraries
+ * (or RIS, BibTeX, CSL-JSON, and Zotero libraries)
  *
  * Run with --help for usage information.
  */
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -23,12 +27,15 @@ import (
 const (
 	CMD_SHOW      = "show"
 	CMD_SHOW_DESC = "Display records or attributes extracted from the " +
-		"provided XML file."
+		"provided library file."
 
 	ARG_SHOW      = "attr"
 	ARG_SHOW_DESC = "Specify \"all\" to show all records, or one of the " +
 		"following to list only specific attributes: " +
-		"Title, Publication, Year, Author, Language, PDF"
+		"Title, Publication, Year, Author, Language, PDF, URL, " +
+		"Author-Clusters (authors' names grouped by identity), " +
+		"Publication-Aliases (publication titles grouped by publication), " +
+		"or Coverage (a summary of how many records are complete)"
 
 	CMD_CREATE      = "create"
 	CMD_CREATE_DESC = "Create a project file for use with reid-convert and reid-search."
@@ -39,6 +46,45 @@ const (
 	ARG_CREATE_DIR      = "dir"
 	ARG_CREATE_DIR_DESC = "Directory to store project files in. This " +
 		"will be created if it does not already exist."
+
+	CMD_REPORT      = "report"
+	CMD_REPORT_DESC = "Report EndNote XML records that could not be loaded " +
+		"(e.g., due to missing fields or an invalid year), and why."
+
+	ARG_REPORT      = "output"
+	ARG_REPORT_DESC = "Report format. Options are: text, csv, json"
+
+	CMD_DEDUPE      = "dedupe"
+	CMD_DEDUPE_DESC = "Find likely duplicate records (e.g., those with " +
+		"similar titles, equal DOIs, or identical PDFs) in the provided " +
+		"library file or project, and optionally write a project that " +
+		"keeps one record from each set of duplicates."
+
+	ARG_DEDUPE_PROJ      = "project"
+	ARG_DEDUPE_PROJ_DESC = "Project file to write, keeping only the " +
+		"record marked with a '*' from each set of duplicates."
+
+	CMD_SYNC      = "sync"
+	CMD_SYNC_DESC = "Update an existing project from a new export of its " +
+		"library, adding new records, updating changed records, and " +
+		"marking records that were removed. Converted text is retained " +
+		"for records whose PDFs have not changed."
+
+	ARG_SYNC_PROJ      = "project"
+	ARG_SYNC_PROJ_DESC = "Project file to update."
+
+	CMD_AUDIT      = "audit"
+	CMD_AUDIT_DESC = "List PDFs in the library's attachment directory " +
+		"(e.g., <library>.Data/PDF) that no record references, and " +
+		"records whose PDFs do not appear to match them, based upon the " +
+		"PDFs' document information and first page text. Use --pdf-dir " +
+		"to specify the attachment directory if it is not found."
+
+	ARG_DEDUPE_DIR      = "dir"
+	ARG_DEDUPE_DIR_DESC = "Directory to store project files in. " +
+		"Required when writing a project from a library file, or to a " +
+		"file other than the input project. Minified text files of the " +
+		"input project are copied into it."
 )
 
 // Command-line configuration items
@@ -50,9 +96,47 @@ var (
 		Bool()
 
 	xmlFile = kingpin.
-		Flag("xml", "EndNote XML file to load").
+		Flag("xml", "Library file to load (EndNote XML or .enl, RIS, BibTeX, CSL-JSON, or zotero.sqlite)").
 		Short('x').
-		Required().
+		String()
+
+	format = kingpin.
+		Flag("format", "Format of the library file. Options are: auto, xml, enl, ris, bibtex, csl-json, zotero. "+
+			"The \"auto\" option selects the format based upon the file extension.").
+		Default("auto").
+		String()
+
+	pdfDir = kingpin.
+		Flag("pdf-dir", "Directory to search for PDFs when the library file "+
+			"does not contain paths to them (CSL-JSON), or the base directory "+
+			"for linked attachments with relative paths (Zotero).").
+		String()
+
+	pathMaps = kingpin.
+			Flag("map-path", "Translate paths beginning with FROM to begin with TO "+
+			"instead, specified as FROM=TO. This is intended for libraries "+
+			"exported on a different machine (e.g., C:\\Users\\me\\Documents=/mnt/share). "+
+			"May be specified multiple times. Mappings are stored in created projects.").
+		Strings()
+
+	keepIncomplete = kingpin.
+			Flag("keep-incomplete", "Keep records that lack PDFs, a publication, "+
+			"a year, or authors, rather than dropping them. These are flagged "+
+			"with their status, and are not converted or searched.").
+		Bool()
+
+	authorAliasFile = kingpin.
+			Flag("author-aliases", "File mapping variants of authors' names "+
+			"onto canonical names, with one author per line, formatted as: "+
+			"\"Canonical name = Variant; Variant; ...\". These are stored in "+
+			"created projects.").
+		String()
+
+	pubAliasFile = kingpin.
+			Flag("publication-aliases", "File mapping variants of publication "+
+			"titles (e.g., abbreviations) onto canonical titles, with one "+
+			"publication per line, formatted as: \"Canonical title = Variant; "+
+			"Variant; ...\". These are stored in created projects.").
 		String()
 
 	langs = kingpin.
@@ -72,15 +156,91 @@ var (
 	cmdCreate        = kingpin.Command(CMD_CREATE, CMD_CREATE_DESC)
 	argCreateProject = cmdCreate.Arg(ARG_CREATE_PROJ, ARG_CREATE_PROJ_DESC).Required().String()
 	argCreateDir     = cmdCreate.Arg(ARG_CREATE_DIR, ARG_CREATE_DIR_DESC).Required().String()
+
+	createBackups = cmdCreate.
+			Flag("backups", "Number of previous versions of the project "+
+			"file to keep (as <file>.bak.1, <file>.bak.2, ...) each time "+
+			"it is saved.").
+		Default("0").
+		Int()
+
+	// report [text|csv|json]
+	cmdReport = kingpin.Command(CMD_REPORT, CMD_REPORT_DESC)
+	argReport = cmdReport.Arg(ARG_REPORT, ARG_REPORT_DESC).Default("text").String()
+
+	// sync <project file>
+	cmdSync        = kingpin.Command(CMD_SYNC, CMD_SYNC_DESC)
+	argSyncProject = cmdSync.Arg(ARG_SYNC_PROJ, ARG_SYNC_PROJ_DESC).Required().String()
+
+	// audit [--skip-contents] [--ocr]
+	cmdAudit = kingpin.Command(CMD_AUDIT, CMD_AUDIT_DESC)
+
+	auditSkipContents = cmdAudit.
+				Flag("skip-contents", "Only list unreferenced PDFs, rather "+
+			"than also comparing every PDF against its record.").
+		Bool()
+
+	auditOCR = cmdAudit.
+			Flag("ocr", "Use OCR to read the first page of PDFs that lack "+
+			"searchable text. This is slow.").
+		Bool()
+
+	// dedupe [project file] [directory]
+	cmdDedupe        = kingpin.Command(CMD_DEDUPE, CMD_DEDUPE_DESC)
+	argDedupeProject = cmdDedupe.Arg(ARG_DEDUPE_PROJ, ARG_DEDUPE_PROJ_DESC).String()
+	argDedupeDir     = cmdDedupe.Arg(ARG_DEDUPE_DIR, ARG_DEDUPE_DIR_DESC).String()
+
+	dedupeInput = cmdDedupe.
+			Flag(c.FLAG_PROJECT, "Search the specified project file for "+
+			"duplicates, rather than the library file specified via --xml.").
+		Short(c.FLAG_PROJECT_SHORT).
+		String()
+
+	dedupeConfidence = cmdDedupe.
+				Flag("min-confidence", "Minimum confidence (0.0 - 1.0) "+
+			"required to consider records duplicates.").
+		Default(strconv.FormatFloat(reid.DefaultDedupeConfidence, 'f', -1, 64)).
+		Float64()
+
+	dedupePDFs = cmdDedupe.
+			Flag("compare-pdfs", "Also consider records with identical PDFs to "+
+			"be duplicates. This requires reading every PDF.").
+		Bool()
 )
 
+// Print an optional field, only if it is present
+func showField(name, value string) {
+	if len(value) != 0 {
+		fmt.Printf("%s: %s\n", name, value)
+	}
+}
+
 func showAll(records []reid.Record) {
 	for _, record := range records {
 		fmt.Printf("Title: %s\nAuthor(s): %s\nPublication: %s\n"+
-			"Year: %d\nLanguage: %s\nMetadata Hash: %s\n\n",
+			"Year: %d\nLanguage: %s\nMetadata Hash: %s\n",
 			record.Title, strings.Join(record.Authors, " / "),
 			record.Publication, record.Year, record.Language,
 			record.HashString())
+
+		id, scheme := record.Identity()
+		fmt.Printf("Identifier: %s (%s)\n", id.String(), scheme)
+		showField("Type", record.RefType)
+		if record.RecNumber != 0 {
+			showField("Record Number", strconv.Itoa(record.RecNumber))
+		}
+		showField("Volume", record.Volume)
+		showField("Issue", record.Issue)
+		showField("Pages", record.Pages)
+		showField("DOI", record.DOI)
+		showField("Accession Number", record.AccessionNum)
+		showField("Keywords", strings.Join(record.Keywords, " / "))
+		showField("URL(s)", strings.Join(record.URLs, " "))
+		showField("Label", record.Label)
+		showField("Abstract", record.Abstract)
+		showField("Research Notes", record.ResearchNotes)
+		showField("Status", strings.Join(record.Status, ", "))
+		fmt.Println()
 	}
 }
 
@@ -98,8 +258,9 @@ func showYears(records []reid.Record) {
 
 func showPublications(records []reid.Record) {
 	var pubSet = reid.NewStringSet(500)
+	var pubIndex = reid.NewPublicationIndex(records, pubAliases)
 	for _, rec := range records {
-		pubSet.CaseInsensitveInsert(rec.Publication)
+		pubSet.CaseInsensitveInsert(pubIndex.Canonical(rec.Publication))
 	}
 
 	sort.Strings(pubSet.Values)
@@ -134,6 +295,37 @@ func showAuthors(records []reid.Record) {
 	}
 }
 
+var authorAliases reid.AuthorAliases
+var pubAliases reid.PublicationAliases
+
+func showPublicationAliases(records []reid.Record) {
+	for _, group := range reid.NewPublicationIndex(records, pubAliases).Groups() {
+		fmt.Println(group.Name)
+		for _, variant := range group.Variants {
+			if variant != group.Name {
+				fmt.Printf("    %s\n", variant)
+			}
+		}
+	}
+}
+
+func showAuthorClusters(records []reid.Record) {
+	var names []string
+	for _, rec := range records {
+		names = append(names, rec.Authors...)
+	}
+
+	for _, cluster := range reid.NewAuthorIndex(names, authorAliases).Clusters() {
+		fmt.Println(cluster.Name)
+		if len(cluster.Variants) > 1 || cluster.Variants[0] != cluster.Name {
+			sort.Strings(cluster.Variants)
+			for _, variant := range cluster.Variants {
+				fmt.Printf("    %s\n", variant)
+			}
+		}
+	}
+}
+
 func showLanguages(records []reid.Record) {
 	var langSet = reid.NewStringSet(10)
 	for _, rec := range records {
@@ -163,12 +355,277 @@ func showPDFs(records []reid.Record) {
 	}
 }
 
+func showURLs(records []reid.Record) {
+	var urlSet = reid.NewStringSet(5000)
+	for _, rec := range records {
+		for _, url := range rec.URLs {
+			urlSet.Insert(url)
+		}
+	}
+
+	sort.Strings(urlSet.Values)
+	for _, val := range urlSet.Values {
+		fmt.Println(val)
+	}
+}
+
+func showCoverage(records []reid.Record) {
+	fmt.Print(reid.RecordCoverage(records).Pretty("\n", false))
+}
+
 type showFunc func(records []reid.Record)
 
+// Select a library format based upon the file extension, defaulting to XML
+func detectFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".enl":
+		return "enl"
+	case ".ris":
+		return "ris"
+	case ".bib", ".bibtex":
+		return "bibtex"
+	case ".json":
+		return "csl-json"
+	case ".sqlite":
+		return "zotero"
+	default:
+		return "xml"
+	}
+}
+
+func loadConfig() (reid.LoadConfig, error) {
+	config := reid.LoadConfig{
+		Languages:      *langs,
+		PDFDir:         *pdfDir,
+		KeepIncomplete: *keepIncomplete,
+	}
+
+	for _, s := range *pathMaps {
+		mapping, err := reid.ParsePathMapping(s)
+		if err != nil {
+			return config, err
+		}
+		config.PathMap = append(config.PathMap, mapping)
+	}
+
+	return config, nil
+}
+
+func loadRecords(config reid.LoadConfig) ([]reid.Record, error) {
+	f := strings.ToLower(*format)
+	if f == "auto" {
+		f = detectFormat(*xmlFile)
+	}
+
+	switch f {
+	case "xml":
+		records, _, err := reid.LoadRecordsFromXML(*xmlFile, config)
+		return records, err
+	case "enl":
+		return reid.LoadRecordsFromEnl(*xmlFile, config)
+	case "ris":
+		return reid.LoadRecordsFromRIS(*xmlFile, config)
+	case "bib", "bibtex":
+		return reid.LoadRecordsFromBibTeX(*xmlFile, config)
+	case "csl", "csl-json", "json":
+		return reid.LoadRecordsFromCSLJSON(*xmlFile, config)
+	case "zotero":
+		return reid.LoadRecordsFromZotero(*xmlFile, config)
+	default:
+		return []reid.Record{}, fmt.Errorf("Invalid library format: %s", *format)
+	}
+}
+
+func writeReport(report *reid.ImportReport) error {
+	switch strings.ToLower(*argReport) {
+	case "text", "pretty":
+		fmt.Print(report.Pretty("\n"))
+	case "csv":
+		fmt.Print(reid.ImportIssueCSVHeader(",", "\n"))
+		for _, issue := range report.Issues {
+			fmt.Print(issue.CSV(",", "\n"))
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	default:
+		return fmt.Errorf("Invalid report format: %s", *argReport)
+	}
+	return nil
+}
+
+func printDuplicates(records []reid.Record, clusters []reid.DuplicateCluster) {
+	for i, cluster := range clusters {
+		fmt.Printf("Duplicate set %d: %s\n", i+1, cluster.Pretty(records, "\n"))
+	}
+
+	fmt.Printf("%d sets of likely duplicates found; %d records would be removed.\n",
+		len(clusters), len(reid.DuplicatesToRemove(clusters)))
+}
+
+func dedupe(config reid.LoadConfig) error {
+	var records []reid.Record
+	var clusters []reid.DuplicateCluster
+	var project *reid.Project
+	var err error
+
+	dedupeConfig := reid.DedupeConfig{
+		MinConfidence: *dedupeConfidence,
+		ComparePDFs:   *dedupePDFs,
+	}
+
+	if len(*argDedupeProject) != 0 {
+		if _, err := os.Stat(*argDedupeProject); !os.IsNotExist(err) && !*force {
+			return fmt.Errorf("Error: %s already exists. Run with -f if "+
+				"you want to overwrite it.", *argDedupeProject)
+		}
+	}
+
+	if len(*argDedupeProject) != 0 {
+		c.LockProject(*argDedupeProject, true)
+	}
+
+	if len(*dedupeInput) != 0 {
+		// The input is only modified if it is also the output
+		inputIsOutput := *dedupeInput == *argDedupeProject
+		if !inputIsOutput {
+			c.LockProject(*dedupeInput, false)
+		}
+
+		if project, err = reid.LoadProject(*dedupeInput, inputIsOutput); err != nil {
+			return err
+		}
+
+		clusters = project.FindDuplicates(dedupeConfig)
+		for _, entry := range project.Entries {
+			records = append(records, entry.Record)
+		}
+	} else {
+		if records, err = loadRecords(config); err != nil {
+			return err
+		}
+		clusters = reid.FindDuplicates(records, dedupeConfig)
+	}
+
+	printDuplicates(records, clusters)
+
+	if len(*argDedupeProject) == 0 {
+		return nil
+	}
+
+	remove := reid.DuplicatesToRemove(clusters)
+
+	if project != nil {
+		if err = project.RemoveEntries(remove); err != nil {
+			return err
+		}
+
+		// Give a new project its own data directory. If it shared that of
+		// the input, each project's minified text files would appear to be
+		// orphans of the other (e.g., to reid-project fsck).
+		if len(*argDedupeDir) != 0 {
+			copied, err := project.RelocateDataDir(*argDedupeDir, false)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Copied %d minified text files to %s\n", copied, project.DataDir)
+		} else if *dedupeInput != *argDedupeProject {
+			return fmt.Errorf("A project directory must be specified " +
+				"when writing a project to a file other than the input project.")
+		}
+	} else {
+		if len(*argDedupeDir) == 0 {
+			return fmt.Errorf("A project directory must be specified " +
+				"when writing a project from a library file.")
+		}
+
+		var kept []reid.Record
+		for i, j := 0, 0; i < len(records); i++ {
+			if j < len(remove) && remove[j] == i {
+				j++
+			} else {
+				kept = append(kept, records[i])
+			}
+		}
+
+		if project, err = reid.NewProject(*argDedupeDir, kept); err != nil {
+			return err
+		}
+		project.PathMap = config.PathMap
+		project.AuthorAliases = authorAliases
+		project.PublicationAliases = pubAliases
+	}
+
+	return project.Save(*argDedupeProject)
+}
+
+func audit(config reid.LoadConfig) error {
+	// Every record's attachments are of interest
+	config.KeepIncomplete = true
+	config.Languages = nil
+
+	records, err := loadRecords(config)
+	if err != nil {
+		return err
+	}
+
+	dir := *pdfDir
+	if len(dir) == 0 {
+		dir = reid.AttachmentDir(*xmlFile, records)
+	}
+
+	report, err := reid.AuditAttachments(records, reid.AuditConfig{
+		PDFDir:       dir,
+		SkipContents: *auditSkipContents,
+		OCR:          *auditOCR,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(report.Pretty("\n"))
+	return nil
+}
+
+func syncProject(config reid.LoadConfig) error {
+	c.LockProject(*argSyncProject, true)
+
+	project, err := reid.LoadProject(*argSyncProject, true)
+	if err != nil {
+		return err
+	}
+
+	// The library is presumably exported from the same machine as before
+	if len(config.PathMap) == 0 {
+		config.PathMap = project.PathMap
+	} else {
+		project.PathMap = config.PathMap
+	}
+
+	if authorAliases != nil {
+		project.AuthorAliases = authorAliases
+	}
+
+	if pubAliases != nil {
+		project.PublicationAliases = pubAliases
+	}
+
+	records, err := loadRecords(config)
+	if err != nil {
+		return err
+	}
+
+	summary, err := project.Sync(records)
+	fmt.Print(summary.Pretty("\n"))
+	return err
+}
+
 func main() {
 	var err error
 	var show showFunc
 	var records []reid.Record
+	var project *reid.Project
 
 	cmd := c.ParseCommandLine()
 
@@ -178,6 +635,31 @@ func main() {
 		reid.LogLevel = reid.LogLevelDebug
 	}
 
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if len(*authorAliasFile) != 0 {
+		if authorAliases, err = reid.LoadAuthorAliases(*authorAliasFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if len(*pubAliasFile) != 0 {
+		if pubAliases, err = reid.LoadPublicationAliases(*pubAliasFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if len(*xmlFile) == 0 && !(cmd == CMD_DEDUPE && len(*dedupeInput) != 0) {
+		fmt.Fprintln(os.Stderr, "Error: A library file must be specified via --xml.")
+		os.Exit(1)
+	}
+
 	switch cmd {
 	case CMD_SHOW:
 		switch strings.ToLower(*argShow) {
@@ -191,10 +673,18 @@ func main() {
 			show = showTitles
 		case "author", "authors":
 			show = showAuthors
+		case "author-clusters", "clusters":
+			show = showAuthorClusters
+		case "publication-aliases", "pub-aliases":
+			show = showPublicationAliases
 		case "lang", "lanuage", "languages":
 			show = showLanguages
 		case "pdf", "pdfs":
 			show = showPDFs
+		case "url", "urls":
+			show = showURLs
+		case "coverage", "status":
+			show = showCoverage
 		default:
 			fmt.Fprintf(os.Stderr,
 				"Invalid attribute (%s). Run \"help show\" for "+
@@ -202,23 +692,55 @@ func main() {
 			os.Exit(2)
 		}
 
-		if records, err = reid.LoadRecordsFromXML(*xmlFile, *langs); err == nil {
+		if records, err = loadRecords(config); err == nil {
 			show(records)
 		}
 
 	case CMD_CREATE:
+		c.LockProject(*argCreateProject, true)
+
 		if _, err := os.Stat(*argCreateProject); !os.IsNotExist(err) && !*force {
 			fmt.Fprintf(os.Stderr, "Error: %s already exists. Run with -f if "+
 				"you want to overwrite it.\n", *argCreateProject)
 			os.Exit(3)
 		}
 
-		if records, err = reid.LoadRecordsFromXML(*xmlFile, *langs); err == nil {
-			if project, err := reid.NewProject(*argCreateDir, records); err == nil {
+		if records, err = loadRecords(config); err == nil {
+			if project, err = reid.NewProject(*argCreateDir, records); err == nil {
+				project.PathMap = config.PathMap
+				project.AuthorAliases = authorAliases
+				project.PublicationAliases = pubAliases
+				project.Backups = *createBackups
 				err = project.Save(*argCreateProject)
 			}
 		}
 
+	case CMD_REPORT:
+		f := strings.ToLower(*format)
+		if f == "auto" {
+			f = detectFormat(*xmlFile)
+		}
+
+		if f != "xml" {
+			fmt.Fprintln(os.Stderr, "Error: The report command currently "+
+				"supports only EndNote XML files.")
+			os.Exit(2)
+		}
+
+		var report *reid.ImportReport
+		if _, report, err = reid.LoadRecordsFromXML(*xmlFile, config); err == nil {
+			err = writeReport(report)
+		}
+
+	case CMD_SYNC:
+		err = syncProject(config)
+
+	case CMD_DEDUPE:
+		err = dedupe(config)
+
+	case CMD_AUDIT:
+		err = audit(config)
+
 	default:
 		fmt.Fprintf(os.Stderr, "Invalid command: %s\n", cmd)
 		os.Exit(1)
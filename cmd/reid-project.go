@@ -0,0 +1,644 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * reid-project: Manage reid project files
+ *
+ * Run with --help for usage information.
+ */
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"../reid"
+	c "./common"
+)
+
+const (
+	CMD_INFO      = "info"
+	CMD_INFO_DESC = "Show the project's data directory, schema version, " +
+		"and how many of its entries have been converted."
+
+	CMD_LIST      = "list"
+	CMD_LIST_DESC = "List the project's entries and their conversion " +
+		"status. Flags may be used to list only specific entries."
+
+	CMD_SHOW      = "show"
+	CMD_SHOW_DESC = "Show an entry's record in full."
+
+	CMD_REMOVE      = "remove"
+	CMD_REMOVE_DESC = "Remove one or more entries from the project. " +
+		"Their minified text files are not deleted."
+
+	CMD_SET      = "set"
+	CMD_SET_DESC = "Correct a field of an entry's record. Multiple authors " +
+		"or keywords are separated by semicolons. An empty value clears " +
+		"the field. Fields that may be set: " + FIELDS
+
+	FIELDS = "Title, Authors, Publication, Year, DOI, Language, Volume, " +
+		"Issue, Pages, Keywords, Label."
+
+	ARG_ID      = "id"
+	ARG_ID_DESC = "Identifier of the entry (as shown by \"list\"). " +
+		"Former identifiers may also be used."
+
+	ARG_FIELD      = "field"
+	ARG_FIELD_DESC = "Name of the field to set."
+
+	ARG_VALUE      = "value"
+	ARG_VALUE_DESC = "Value of the field."
+
+	CMD_TAG      = "tag"
+	CMD_TAG_DESC = "Add a tag to the specified entries, which may be " +
+		"selected by identifier, by flags, or by a file listing " +
+		"identifiers. Tags may be used to limit the entries converted, " +
+		"searched, or listed (see --tag and --exclude-tag)."
+
+	CMD_UNTAG      = "untag"
+	CMD_UNTAG_DESC = "Remove a tag from the specified entries, which are " +
+		"selected as for \"tag\"."
+
+	CMD_TAGS      = "tags"
+	CMD_TAGS_DESC = "List the tags in use, and how many entries have each."
+
+	CMD_NOTE      = "note"
+	CMD_NOTE_DESC = "Set an entry's notes. If no text is provided, the " +
+		"notes are cleared."
+
+	ARG_TAG      = "tag"
+	ARG_TAG_DESC = "Name of the tag. Tags are not case-sensitive."
+
+	ARG_IDS      = "id"
+	ARG_IDS_DESC = "Identifiers of the entries (as shown by \"list\")."
+
+	ARG_NOTE      = "text"
+	ARG_NOTE_DESC = "Text of the notes."
+
+	CMD_FSCK      = "fsck"
+	CMD_FSCK_DESC = "Check the project's entries against the contents of its " +
+		"data directory. Reports minified text files that are missing, " +
+		"stale (older than, or not corresponding to, their PDFs), " +
+		"suspiciously small, or orphaned (not listed by any entry), as " +
+		"well as missing PDFs. Use --repair to fix these."
+
+	CMD_MERGE      = "merge"
+	CMD_MERGE_DESC = "Merge the entries of one or more other projects " +
+		"(e.g., created from other libraries) into the project. Entries " +
+		"with equal DOIs or metadata hashes are merged, and differences " +
+		"in their metadata are reported."
+
+	ARG_MERGE_SRC      = "source"
+	ARG_MERGE_SRC_DESC = "Project file(s) to merge."
+
+	CMD_ROOTS      = "roots"
+	CMD_ROOTS_DESC = "Show the project's data and library roots, and how " +
+		"many files within them are missing. If --data or --library is " +
+		"specified, re-point the corresponding root (e.g., after moving " +
+		"the project or library) and save the project."
+)
+
+var (
+	projectFile = kingpin.
+			Flag(c.FLAG_PROJECT, c.FLAG_PROJECT_DESC).
+			Short(c.FLAG_PROJECT_SHORT).
+			Required().
+			String()
+
+	debug   = kingpin.Flag(c.FLAG_DEBUG, c.FLAG_DEBUG_DESC).Bool()
+	verbose = kingpin.Flag(c.FLAG_VERBOSE, c.FLAG_VERBOSE_DESC).Bool()
+	version = kingpin.Flag(c.FLAG_VERSION, c.FLAG_VERSION_DESC).Bool()
+
+	// info
+	cmdInfo = kingpin.Command(CMD_INFO, CMD_INFO_DESC)
+
+	// list [-y year] [-a author] [-P publication]
+	cmdList = kingpin.Command(CMD_LIST, CMD_LIST_DESC)
+
+	listYears = cmdList.
+			Flag("year", "List entries from the specified year. "+
+			"May be specified multiple times.").
+		Short('y').
+		Ints()
+
+	listAuthors = cmdList.
+			Flag("author", "List entries by the specified author. "+
+			"May be specified multiple times.").
+		Short('a').
+		Strings()
+
+	listPublications = cmdList.
+				Flag("publication", "List entries from the specified "+
+			"publication. May be specified multiple times.").
+		Short('P').
+		Strings()
+
+	listStatus = cmdList.
+			Flag("status", "List only entries whose status contains the "+
+			"specified text (e.g., \"not converted\").").
+		String()
+
+	listTags = cmdList.
+			Flag("tag", "List only entries with the specified tag. "+
+			"May be specified multiple times.").
+		Strings()
+
+	listExcludeTags = cmdList.
+			Flag("exclude-tag", "Do not list entries with the specified "+
+			"tag. May be specified multiple times.").
+		Strings()
+
+	// show <id>
+	cmdShow = kingpin.Command(CMD_SHOW, CMD_SHOW_DESC)
+	argShow = cmdShow.Arg(ARG_ID, ARG_ID_DESC).Required().String()
+
+	// remove <id>...
+	cmdRemove = kingpin.Command(CMD_REMOVE, CMD_REMOVE_DESC)
+	argRemove = cmdRemove.Arg(ARG_ID, ARG_ID_DESC).Required().Strings()
+
+	// set <id> <field> <value>
+	cmdSet      = kingpin.Command(CMD_SET, CMD_SET_DESC)
+	argSetID    = cmdSet.Arg(ARG_ID, ARG_ID_DESC).Required().String()
+	argSetField = cmdSet.Arg(ARG_FIELD, ARG_FIELD_DESC).Required().String()
+	argSetValue = cmdSet.Arg(ARG_VALUE, ARG_VALUE_DESC).Required().String()
+
+	// tag <tag> [id]... [-y year] [-a author] [-P publication] [-t title] [--ids file]
+	cmdTag     = kingpin.Command(CMD_TAG, CMD_TAG_DESC)
+	argTag     = cmdTag.Arg(ARG_TAG, ARG_TAG_DESC).Required().String()
+	argTagIDs  = cmdTag.Arg(ARG_IDS, ARG_IDS_DESC).Strings()
+	tagSelects = selectionFlags(cmdTag)
+
+	// untag <tag> [id]... [-y year] [-a author] [-P publication] [-t title] [--ids file]
+	cmdUntag     = kingpin.Command(CMD_UNTAG, CMD_UNTAG_DESC)
+	argUntag     = cmdUntag.Arg(ARG_TAG, ARG_TAG_DESC).Required().String()
+	argUntagIDs  = cmdUntag.Arg(ARG_IDS, ARG_IDS_DESC).Strings()
+	untagSelects = selectionFlags(cmdUntag)
+
+	// tags
+	cmdTags = kingpin.Command(CMD_TAGS, CMD_TAGS_DESC)
+
+	// note <id> [text]
+	cmdNote     = kingpin.Command(CMD_NOTE, CMD_NOTE_DESC)
+	argNoteID   = cmdNote.Arg(ARG_ID, ARG_ID_DESC).Required().String()
+	argNoteText = cmdNote.Arg(ARG_NOTE, ARG_NOTE_DESC).String()
+
+	// fsck [--repair] [--orphans keep|delete|adopt] [--force]
+	cmdFsck = kingpin.Command(CMD_FSCK, CMD_FSCK_DESC)
+
+	fsckRepair = cmdFsck.
+			Flag("repair", "Repair the problems found and save the project. "+
+			"Missing and stale minified text files are removed from their "+
+			"entries (deleting stale files), so that the entries are "+
+			"converted by the next reid-convert run.").
+		Bool()
+
+	fsckOrphans = cmdFsck.
+			Flag("orphans", "When repairing, what to do with orphaned "+
+			"minified text files: keep, delete, or adopt (assign them to "+
+			"unconverted entries whose PDFs they correspond to). Only orphans "+
+			"converted from this project's PDFs are deleted, unless --force "+
+			"is specified.").
+		Default(reid.OrphansKeep).
+		Enum(reid.OrphansKeep, reid.OrphansDelete, reid.OrphansAdopt)
+
+	fsckForce = cmdFsck.
+			Flag("force", "With --orphans delete, also delete orphans that "+
+			"were not converted from any of this project's PDFs. Do not use "+
+			"this with a data directory shared with other projects.").
+		Bool()
+
+	// merge <source project>...
+	cmdMerge        = kingpin.Command(CMD_MERGE, CMD_MERGE_DESC)
+	argMergeSources = cmdMerge.Arg(ARG_MERGE_SRC, ARG_MERGE_SRC_DESC).Required().Strings()
+
+	mergeOutput = cmdMerge.
+			Flag("output", "Write the merged project to the specified file, "+
+			"rather than updating the project file.").
+		Short('o').
+		String()
+
+	mergeDir = cmdMerge.
+			Flag("dir", "Data directory of the merged project. By default, "+
+			"the project's data directory is used. All minified text files "+
+			"are copied into this directory. Required with --output, such "+
+			"that the projects do not share a data directory.").
+		Short('d').
+		String()
+
+	mergeLink = cmdMerge.
+			Flag("link", "Create symbolic links to other projects' minified "+
+			"text files, rather than copying them.").
+		Bool()
+
+	// roots [--data <dir>] [--library <dir>]
+	cmdRoots = kingpin.Command(CMD_ROOTS, CMD_ROOTS_DESC)
+
+	rootsData = cmdRoots.
+			Flag("data", "New location of the project's data directory.").
+			String()
+
+	rootsLibrary = cmdRoots.
+			Flag("library", "New location of the directory containing the "+
+			"library's PDFs (e.g., \"My Library.Data/PDF\").").
+		String()
+)
+
+// Flags selecting the entries to tag or untag
+type selection struct {
+	years        *[]int
+	authors      *[]string
+	publications *[]string
+	titles       *[]string
+	idFile       *string
+}
+
+func selectionFlags(cmd *kingpin.CmdClause) selection {
+	return selection{
+		years: cmd.Flag("year", "Select entries from the specified year. "+
+			"May be specified multiple times.").Short('y').Ints(),
+
+		authors: cmd.Flag("author", "Select entries by the specified "+
+			"author. May be specified multiple times.").Short('a').Strings(),
+
+		publications: cmd.Flag("publication", "Select entries from the "+
+			"specified publication. May be specified multiple times.").
+			Short('P').Strings(),
+
+		titles: cmd.Flag("title", "Select entries with the specified "+
+			"title. May be specified multiple times.").Short('t').Strings(),
+
+		idFile: cmd.Flag("ids", "Select the entries whose identifiers are "+
+			"listed, one per line, in the specified file (or standard "+
+			"input, if \"-\"). Blank lines and lines beginning with # "+
+			"are ignored.").String(),
+	}
+}
+
+// Read a list of entry identifiers, one per line
+func readIDs(r io.Reader) ([]string, error) {
+	var ids []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, strings.Fields(line)[0])
+	}
+
+	return ids, scanner.Err()
+}
+
+// Returns the entries selected by identifier and by flags
+func (s selection) entries(project *reid.Project, ids []string) ([]*reid.ProjectEntry, error) {
+	var records []reid.RecordToConvert
+
+	if len(*s.idFile) != 0 {
+		in := os.Stdin
+		if *s.idFile != "-" {
+			f, err := os.Open(*s.idFile)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		listed, err := readIDs(in)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, listed...)
+	}
+
+	for _, year := range *s.years {
+		records = append(records, reid.RecordToConvert{Year: year})
+	}
+
+	for _, author := range *s.authors {
+		records = append(records, reid.RecordToConvert{Author: author})
+	}
+
+	for _, publication := range *s.publications {
+		records = append(records, reid.RecordToConvert{Publication: publication})
+	}
+
+	for _, title := range *s.titles {
+		records = append(records, reid.RecordToConvert{Title: title})
+	}
+
+	if len(ids) == 0 && len(records) == 0 {
+		return nil, fmt.Errorf("No entries were specified.")
+	}
+
+	entries, err := project.EntriesByID(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) != 0 {
+		selected, err := project.ListEntries(records)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, selected...)
+	}
+
+	return entries, nil
+}
+
+func tag(project *reid.Project) error {
+	entries, err := tagSelects.entries(project, *argTagIDs)
+	if err != nil {
+		return err
+	}
+
+	n, err := project.TagEntries(*argTag, entries)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Tagged %d entries with \"%s\".\n", n, *argTag)
+	return project.Save(*projectFile)
+}
+
+func untag(project *reid.Project) error {
+	entries, err := untagSelects.entries(project, *argUntagIDs)
+	if err != nil {
+		return err
+	}
+
+	n, err := project.UntagEntries(*argUntag, entries)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed \"%s\" from %d entries.\n", *argUntag, n)
+	return project.Save(*projectFile)
+}
+
+func note(project *reid.Project) error {
+	entry, err := project.SetNotes(*argNoteID, *argNoteText)
+	if err != nil {
+		return err
+	}
+
+	if err = project.Save(*projectFile); err != nil {
+		return err
+	}
+
+	fmt.Print(project.PrettyEntry(entry, "\n"))
+	return nil
+}
+
+func roots() error {
+	modify := len(*rootsData) != 0 || len(*rootsLibrary) != 0
+	c.LockProject(*projectFile, modify)
+
+	// The project's roots may not exist if it has been moved
+	project, err := reid.LoadProjectUnchecked(*projectFile)
+	if err != nil {
+		return err
+	}
+
+	if modify {
+		if err = project.SetRoots(*rootsData, *rootsLibrary); err != nil {
+			return err
+		}
+
+		if err = project.Save(*projectFile); err != nil {
+			return err
+		}
+	}
+
+	fmt.Print(project.Roots().Pretty("\n"))
+	return nil
+}
+
+func list(project *reid.Project) error {
+	var records []reid.RecordToConvert
+	var shown int
+
+	for _, year := range *listYears {
+		records = append(records, reid.RecordToConvert{Year: year})
+	}
+
+	for _, author := range *listAuthors {
+		records = append(records, reid.RecordToConvert{Author: author})
+	}
+
+	for _, publication := range *listPublications {
+		records = append(records, reid.RecordToConvert{Publication: publication})
+	}
+
+	project.RestrictToTags(reid.TagFilter{Include: *listTags, Exclude: *listExcludeTags})
+
+	entries, err := project.ListEntries(records)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		status := project.EntryStatus(entry)
+		if !strings.Contains(status, *listStatus) {
+			continue
+		}
+
+		fmt.Printf("%s  %-14s %s\n", entry.Hash, status, entry.Record.String())
+		shown++
+	}
+
+	fmt.Printf("%d of %d entries listed.\n", shown, len(project.Entries))
+	return nil
+}
+
+func show(project *reid.Project) error {
+	i, err := project.FindEntry(*argShow)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(project.PrettyEntry(&project.Entries[i], "\n"))
+	return nil
+}
+
+func remove(project *reid.Project) error {
+	removed, err := project.RemoveEntriesByID(*argRemove)
+	if err != nil {
+		return err
+	}
+
+	for i := range removed {
+		fmt.Printf("Removed: %s\n", removed[i].String())
+	}
+
+	return project.Save(*projectFile)
+}
+
+func set(project *reid.Project) error {
+	entry, err := project.SetField(*argSetID, *argSetField, *argSetValue)
+	if err != nil {
+		return err
+	}
+
+	if err = project.Save(*projectFile); err != nil {
+		return err
+	}
+
+	fmt.Print(project.PrettyEntry(entry, "\n"))
+	return nil
+}
+
+func fsck(project *reid.Project) error {
+	report, err := project.Fsck(reid.FsckConfig{
+		Repair:  *fsckRepair,
+		Orphans: *fsckOrphans,
+		Force:   *fsckForce,
+	})
+	fmt.Print(report.Pretty("\n"))
+	if err != nil {
+		return err
+	}
+
+	if *fsckRepair {
+		return project.Save(*projectFile)
+	}
+
+	if report.Problems() != 0 {
+		fmt.Println("Run with --repair to fix these problems.")
+	}
+	return nil
+}
+
+func merge(project *reid.Project) error {
+	output := *projectFile
+	if len(*mergeOutput) != 0 {
+		output = *mergeOutput
+	}
+
+	// Otherwise, e.g., fsck --orphans delete could delete the other project's
+	// minified text files
+	if output != *projectFile && len(*mergeDir) == 0 {
+		return fmt.Errorf("A data directory (--dir) must be specified when " +
+			"writing the merged project to another file, so that it does not " +
+			"share that of " + *projectFile + ".")
+	}
+
+	if len(*mergeDir) != 0 {
+		copied, err := project.RelocateDataDir(*mergeDir, *mergeLink)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Copied %d minified text files to %s\n", copied, project.DataDir)
+	}
+
+	if output != *projectFile {
+		c.LockProject(output, true)
+	}
+
+	for _, source := range *argMergeSources {
+		// Sources are locked exclusively only if they are also the output
+		exclusive := source == *projectFile || source == output
+		if !exclusive {
+			c.LockProject(source, false)
+		}
+
+		src, err := reid.LoadProject(source, exclusive)
+		if err != nil {
+			return err
+		}
+
+		summary, err := project.Merge(src, *mergeLink)
+		fmt.Printf("Merged %s:\n%s\n", source, summary.Pretty("\n"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return project.Save(output)
+}
+
+func main() {
+	cmd := c.ParseCommandLine()
+
+	if *verbose {
+		reid.LogLevel = reid.LogLevelVerbose
+	} else if *debug {
+		reid.LogLevel = reid.LogLevelDebug
+	}
+
+	if cmd == CMD_ROOTS {
+		if err := roots(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
+	// Only edits require exclusive use of the project
+	exclusive := true
+	switch cmd {
+	case CMD_INFO, CMD_LIST, CMD_SHOW, CMD_TAGS:
+		exclusive = false
+	case CMD_FSCK:
+		exclusive = *fsckRepair
+	}
+	c.LockProject(*projectFile, exclusive)
+
+	project, err := reid.LoadProject(*projectFile, exclusive)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	switch cmd {
+	case CMD_INFO:
+		fmt.Print(project.Info().Pretty("\n"))
+
+	case CMD_LIST:
+		err = list(project)
+
+	case CMD_SHOW:
+		err = show(project)
+
+	case CMD_REMOVE:
+		err = remove(project)
+
+	case CMD_SET:
+		err = set(project)
+
+	case CMD_TAG:
+		err = tag(project)
+
+	case CMD_UNTAG:
+		err = untag(project)
+
+	case CMD_TAGS:
+		fmt.Print(reid.PrettyTags(project.Tags(), "\n"))
+
+	case CMD_NOTE:
+		err = note(project)
+
+	case CMD_FSCK:
+		err = fsck(project)
+
+	case CMD_MERGE:
+		err = merge(project)
+
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid command: %s\n", cmd)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(-1)
+	}
+}
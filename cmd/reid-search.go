@@ -72,6 +72,7 @@ func main() {
 	var err error
 	var csvSep = ","
 	var eol = "\n"
+	var coverage bool
 
 	kingpin.
 		Flag("term", "Search for the specified term or phrase. "+
@@ -109,6 +110,16 @@ func main() {
 		Short('P').
 		StringsVar(&searchConfig.Publications)
 
+	kingpin.
+		Flag("tag", "Limit search to entries with the specified tag. "+
+			"May be specified multiple times to expand search to multiple tags.").
+		StringsVar(&searchConfig.Tags)
+
+	kingpin.
+		Flag("exclude-tag", "Exclude entries with the specified tag from the search. "+
+			"May be specified multiple times.").
+		StringsVar(&searchConfig.ExcludeTags)
+
 	kingpin.
 		Flag("format", "Format of results. Options are: pretty, csv, csv-no-hdr").
 		Short('f').
@@ -121,6 +132,12 @@ func main() {
 		Short('o').
 		StringVar(&outfilename)
 
+	kingpin.
+		Flag("coverage", "Print the number of project entries that can be "+
+			"searched (i.e., are complete and have been converted), and "+
+			"why others cannot, and then exit.").
+		BoolVar(&coverage)
+
 	c.ParseCommandLine()
 
 	format := validateFormat(formatStr)
@@ -135,12 +152,19 @@ func main() {
 		reid.LogLevel = reid.LogLevelDebug
 	}
 
-	project, err := reid.LoadProject(*projectFile)
+	c.LockProject(*projectFile, false)
+
+	project, err := reid.LoadProject(*projectFile, false)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(2)
 	}
 
+	if coverage {
+		fmt.Print(project.Coverage().Pretty(eol, true))
+		os.Exit(0)
+	}
+
 	results, err := project.Search(searchConfig)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
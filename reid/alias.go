@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * User-provided alias files, mapping variants of names (e.g., of authors or
+ * publications) onto canonical names
+ */
+
+package reid
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+ * Load an alias file. Each line lists a canonical name, followed by an '='
+ * and a semicolon-separated list of variants. Blank lines and those beginning
+ * with '#' are ignored. For example:
+ *
+ *	# Canonical name = Variant; Variant; ...
+ *	Smith, John A. = J. Smith; Smith JA; Smith, Johnny
+ *	IEEE Transactions on Information Theory = IEEE Trans. Inf. Theory
+ *
+ * The returned map is keyed on key(variant), and includes the canonical
+ * names themselves.
+ */
+func loadAliasFile(filename string, key func(string) string) (map[string]string, error) {
+	aliases := make(map[string]string)
+
+	infile, err := os.Open(filename)
+	if err != nil {
+		return aliases, err
+	}
+	defer infile.Close()
+
+	scanner := bufio.NewScanner(infile)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return aliases, fmt.Errorf("%s:%d: Expected \"Canonical name = Variant; ...\"",
+				filename, lineNum)
+		}
+
+		canonical := strings.TrimSpace(line[:i])
+		aliases[key(canonical)] = canonical
+		for _, variant := range strings.Split(line[i+1:], ";") {
+			if variant = strings.TrimSpace(variant); len(variant) != 0 {
+				aliases[key(variant)] = canonical
+			}
+		}
+	}
+
+	return aliases, scanner.Err()
+}
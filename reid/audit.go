@@ -0,0 +1,273 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Audit of a library's attachments
+ *
+ * Reports PDFs within a library's attachment directory (e.g., the EndNote
+ * <library>.Data/PDF directory) that no record references, and records whose
+ * PDFs do not appear to match them. The latter are identified by comparing
+ * each record's title and authors against the PDF's document information
+ * (as reported by pdfinfo) and the text of its first page.
+ */
+
+package reid
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type AuditConfig struct {
+	PDFDir       string // Directory containing the library's attachments
+	SkipContents bool   // Don't compare PDFs' contents against their records
+	OCR          bool   // Use OCR for PDFs lacking searchable text
+}
+
+// Title words shorter than this are not compared, as they're too likely to
+// appear on any page
+const auditMinTitleWord = 4
+
+// Likewise, for family names
+const auditMinFamilyName = 4
+
+// A PDF that may not belong to the record it is attached to
+type AttachmentIssue struct {
+	Record Record
+	PDF    string
+	Detail string
+}
+
+type AuditReport struct {
+	PDFDir       string            // Directory that was audited
+	Attachments  int               // Number of PDFs within PDFDir
+	Unreferenced []string          // PDFs not referenced by any record
+	Mismatched   []AttachmentIssue // PDFs that do not appear to match their records
+	Unverified   []AttachmentIssue // PDFs whose contents could not be compared
+}
+
+func writeAttachmentIssues(b *bytes.Buffer, what string, issues []AttachmentIssue, eol string) {
+	fmt.Fprintf(b, "%s: %d%s", what, len(issues), eol)
+	for _, issue := range issues {
+		fmt.Fprintf(b, "   %s%s", issue.Record.String(), eol)
+		fmt.Fprintf(b, "      %s%s", issue.PDF, eol)
+		fmt.Fprintf(b, "      %s%s", issue.Detail, eol)
+	}
+}
+
+func (r AuditReport) Pretty(eol string) string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "Attachment directory: %s%s", r.PDFDir, eol)
+	fmt.Fprintf(&b, "PDFs: %d%s", r.Attachments, eol)
+
+	fmt.Fprintf(&b, "Unreferenced PDFs: %d%s", len(r.Unreferenced), eol)
+	for _, pdf := range r.Unreferenced {
+		fmt.Fprintf(&b, "   %s%s", pdf, eol)
+	}
+
+	writeAttachmentIssues(&b, "Likely mismatched PDFs", r.Mismatched, eol)
+	writeAttachmentIssues(&b, "Unverified PDFs", r.Unverified, eol)
+
+	return b.String()
+}
+
+/*
+ * Returns the directory containing a library's attachments: the EndNote
+ * <library>.Data/PDF or Zotero storage directory alongside the library file,
+ * if it exists, or otherwise the deepest directory containing all of the
+ * records' PDFs.
+ */
+func AttachmentDir(libraryFile string, records []Record) string {
+	base := strings.TrimSuffix(libraryFile, filepath.Ext(libraryFile))
+
+	for _, dir := range []string{
+		filepath.Join(base+".Data", "PDF"),
+		filepath.Join(filepath.Dir(libraryFile), "storage"),
+	} {
+		dir = resolvePathCase(dir)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+
+	return libraryRootOf(records)
+}
+
+// Returns the PDFs within a directory
+func listPDFs(dir string) ([]string, error) {
+	var pdfs []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isPDF(path) {
+			pdfs = append(pdfs, path)
+		}
+		return nil
+	})
+
+	sort.Strings(pdfs)
+	return pdfs, err
+}
+
+// Returns the fraction of `words` found within (folded) `text`, and the
+// number that were compared
+func wordsFound(words []string, text string, minLen int) (float64, int) {
+	var compared, found int
+
+	for _, word := range words {
+		if word = foldName(word); len(word) < minLen {
+			continue
+		}
+		compared++
+		if strings.Contains(text, word) {
+			found++
+		}
+	}
+
+	if compared == 0 {
+		return 0, 0
+	}
+	return float64(found) / float64(compared), compared
+}
+
+// Returns the text of a PDF's first page, using OCR if requested and it
+// lacks searchable text
+func firstPageText(pdf string, ocr bool) string {
+	text, err := pdfToText(pdf, 1, 1)
+	if err != nil {
+		Debugf("Failed to extract text from %s: %s\n", pdf, err)
+	}
+
+	if ocr && len(strings.TrimSpace(text)) < enrichMinTextLen {
+		Debugf("Using OCR to extract text from: %s\n", pdf)
+		if text, err = pdfToTextOCR(pdf, 1, 1); err != nil {
+			Debugf("Failed to OCR %s: %s\n", pdf, err)
+		}
+	}
+
+	return text
+}
+
+/*
+ * Compare a record against the contents of one of its PDFs. Returns whether
+ * the PDF appears to match, whether there was enough information to tell,
+ * and a description of the comparison.
+ */
+func checkAttachment(r *Record, pdf string, config AuditConfig) (bool, bool, string) {
+	var evidence []string
+	var matched, verifiable bool
+
+	info, err := pdfInfo(pdf)
+	if err != nil {
+		Debugf("Failed to read document information from %s: %s\n", pdf, err)
+	}
+
+	text := firstPageText(pdf, config.OCR)
+	haveText := len(strings.TrimSpace(text)) >= enrichMinTextLen
+	folded := foldName(text + " " + info["Title"] + " " + info["Author"])
+
+	if infoTitle := info["Title"]; len(Reduce(infoTitle)) >= 10 {
+		similarity := newBigrams(r.Title).similarity(newBigrams(infoTitle))
+		evidence = append(evidence, fmt.Sprintf("pdfinfo title %.0f%% similar (\"%s\")",
+			100*similarity, infoTitle))
+		verifiable = true
+		matched = matched || similarity >= 0.6
+	}
+
+	if haveText {
+		found, compared := wordsFound(strings.Fields(r.Title), folded, auditMinTitleWord)
+		if compared != 0 {
+			evidence = append(evidence, fmt.Sprintf("%.0f%% of title words on first page", 100*found))
+			verifiable = true
+			matched = matched || found >= 0.5
+		}
+	}
+
+	if haveText || len(info["Author"]) != 0 {
+		var families []string
+		for _, author := range r.Authors {
+			families = append(families, ParseAuthorName(author).Family)
+		}
+
+		found, compared := wordsFound(families, folded, auditMinFamilyName)
+		if compared != 0 {
+			evidence = append(evidence, fmt.Sprintf("%.0f%% of authors found", 100*found))
+			verifiable = true
+			matched = matched || found > 0
+		}
+	}
+
+	if !verifiable {
+		return true, false, "No searchable text or document information"
+	}
+
+	return matched, true, strings.Join(evidence, "; ")
+}
+
+/*
+ * Audit the attachments of a library's records. PDFs within config.PDFDir not
+ * referenced by any record are reported, as are records whose PDFs do not
+ * appear to match them (unless config.SkipContents is set).
+ */
+func AuditAttachments(records []Record, config AuditConfig) (AuditReport, error) {
+	report := AuditReport{PDFDir: config.PDFDir}
+
+	if len(config.PDFDir) == 0 {
+		return report, fmt.Errorf("No attachment directory was specified")
+	}
+
+	pdfs, err := listPDFs(config.PDFDir)
+	if err != nil {
+		return report, err
+	}
+	report.Attachments = len(pdfs)
+
+	// References are matched by path, or by pdfKey() in case the records'
+	// paths were translated differently
+	referenced := make(map[string]bool)
+	for i := range records {
+		for _, pdf := range records[i].PDFs {
+			referenced[filepath.Clean(pdf)] = true
+			referenced[pdfKey(pdf)] = true
+		}
+	}
+
+	for _, pdf := range pdfs {
+		if !referenced[filepath.Clean(pdf)] && !referenced[pdfKey(pdf)] {
+			report.Unreferenced = append(report.Unreferenced, pdf)
+		}
+	}
+
+	if config.SkipContents {
+		return report, nil
+	}
+
+	for i := range records {
+		r := &records[i]
+		for _, pdf := range r.PDFs {
+			if _, err := os.Stat(pdf); err != nil {
+				continue
+			}
+
+			Verbosef("Checking %s against: %s\n", pdf, r.String())
+
+			matched, verified, detail := checkAttachment(r, pdf, config)
+			issue := AttachmentIssue{Record: *r, PDF: pdf, Detail: detail}
+
+			if !verified {
+				report.Unverified = append(report.Unverified, issue)
+			} else if !matched {
+				report.Mismatched = append(report.Mismatched, issue)
+			}
+		}
+	}
+
+	return report, nil
+}
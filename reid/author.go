@@ -0,0 +1,439 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Author name parsing and identity clustering
+ *
+ * Libraries frequently list the same author in a number of ways
+ * (e.g., "Smith, J.", "Smith, John", "J. Smith", "Smith JA"). Names are parsed
+ * into family and given names, and variants that are compatible with one
+ * another are clustered into a single identity with a canonical display name.
+ *
+ * Users may override this via an alias file, which maps variants onto a
+ * canonical name. See LoadAuthorAliases().
+ */
+
+package reid
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Lowercase particles that are considered part of a family name when a name
+// is written as "Given Family" (e.g., "Ludwig van Beethoven")
+var familyParticles = map[string]bool{
+	"van": true, "von": true, "der": true, "den": true, "de": true,
+	"del": true, "della": true, "di": true, "da": true, "du": true,
+	"dos": true, "das": true, "la": true, "le": true, "ter": true,
+	"bin": true, "al": true,
+}
+
+// Lowercase words that indicate a name is that of an organization
+// (e.g., "National Institute of Standards and Technology")
+var organizationWords = map[string]bool{
+	"of": true, "and": true, "for": true, "the": true, "on": true, "&": true,
+}
+
+// Names without a comma and with more than this many words are assumed to be
+// organizations, and are not parsed
+const maxPersonNameWords = 4
+
+var nameSuffixes = map[string]bool{
+	"jr": true, "sr": true, "ii": true, "iii": true, "iv": true,
+}
+
+type AuthorName struct {
+	Family string   // Family name (e.g., "van der Berg")
+	Given  []string // Given names and/or initials, in order (e.g., "John", "A")
+}
+
+// Lowercase and remove everything but letters and digits
+func foldName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+func isSuffix(s string) bool {
+	return nameSuffixes[foldName(s)]
+}
+
+// Returns true for tokens such as "JA", which are commonly used in place of
+// "J. A." (e.g., "Smith JA")
+func isInitials(s string) bool {
+	if len(s) == 0 || len(s) > 3 {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsUpper(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Split given names into names and initials
+// (e.g., "John A." -> John, A  and  "J.-P." -> J, P  and  "JA" -> J, A)
+func splitGiven(s string) []string {
+	var given []string
+
+	tokens := strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '.' || r == '-'
+	})
+
+	for _, token := range tokens {
+		if isSuffix(token) {
+			continue
+		} else if isInitials(token) && len(tokens) == 1 {
+			for _, r := range token {
+				given = append(given, string(r))
+			}
+		} else {
+			given = append(given, token)
+		}
+	}
+
+	return given
+}
+
+// Parse an author's name, as listed in a library record. The following forms
+// are supported:
+//
+//	Family, Given     (e.g., "Smith, John A.")
+//	Given Family      (e.g., "John A. Smith", "Ludwig van Beethoven")
+//	Family Initials   (e.g., "Smith JA")
+func ParseAuthorName(s string) AuthorName {
+	var name AuthorName
+
+	s = strings.Join(strings.Fields(s), " ")
+
+	if strings.Contains(s, ",") {
+		var parts []string
+		for _, part := range strings.Split(s, ",") {
+			if part = strings.TrimSpace(part); len(part) != 0 && !isSuffix(part) {
+				parts = append(parts, part)
+			}
+		}
+
+		if len(parts) != 0 {
+			name.Family = parts[0]
+			name.Given = splitGiven(strings.Join(parts[1:], " "))
+		}
+
+		// Particles may trail the given names (e.g., "Beethoven, L. van")
+		for n := len(name.Given); n > 1 && familyParticles[name.Given[n-1]]; n-- {
+			name.Family = name.Given[n-1] + " " + name.Family
+			name.Given = name.Given[:n-1]
+		}
+		return name
+	}
+
+	var tokens []string
+	for _, token := range strings.Fields(s) {
+		if organizationWords[token] {
+			name.Family = s
+			return name
+		} else if !isSuffix(token) {
+			tokens = append(tokens, token)
+		}
+	}
+
+	if len(tokens) > maxPersonNameWords {
+		name.Family = s
+		return name
+	}
+
+	switch {
+	case len(tokens) == 0:
+		return name
+
+	case len(tokens) == 1:
+		name.Family = tokens[0]
+
+	case isInitials(tokens[len(tokens)-1]) && !isInitials(tokens[0]):
+		name.Family = strings.Join(tokens[:len(tokens)-1], " ")
+		name.Given = splitGiven(tokens[len(tokens)-1])
+
+	default:
+		i := len(tokens) - 1
+		for i > 1 && familyParticles[tokens[i-1]] {
+			i--
+		}
+		name.Family = strings.Join(tokens[i:], " ")
+		name.Given = splitGiven(strings.Join(tokens[:i], " "))
+	}
+
+	return name
+}
+
+// Returns the name formatted as "Family, Given", with initials followed by
+// a period (e.g., "Smith, John A.")
+func (n AuthorName) String() string {
+	var given []string
+	for _, g := range n.Given {
+		if len([]rune(g)) == 1 {
+			g += "."
+		}
+		given = append(given, g)
+	}
+
+	if len(given) == 0 {
+		return n.Family
+	}
+	return n.Family + ", " + strings.Join(given, " ")
+}
+
+func (n AuthorName) initial(i int) rune {
+	for _, r := range n.Given[i] {
+		return unicode.ToLower(r)
+	}
+	return 0
+}
+
+// Names are only clustered with others sharing a family name and first initial
+func (n AuthorName) clusterKey() string {
+	if len(n.Given) == 0 {
+		return foldName(n.Family) + "|"
+	}
+	return foldName(n.Family) + "|" + string(n.initial(0))
+}
+
+// Number of full (i.e., non-initial) given names, followed by the number of
+// given names. Used to select the most complete variant of a name.
+func (n AuthorName) specificity() (int, int) {
+	var full int
+	for _, g := range n.Given {
+		if len([]rune(g)) > 1 {
+			full++
+		}
+	}
+	return full, len(n.Given)
+}
+
+// Returns true if the two names may refer to the same person. The family
+// names must match, and given names must agree as far as both are specified
+// (e.g., "John A." is compatible with "J." and "John", but not "James").
+func (n AuthorName) compatible(other AuthorName) bool {
+	if foldName(n.Family) != foldName(other.Family) {
+		return false
+	}
+
+	for i := 0; i < len(n.Given) && i < len(other.Given); i++ {
+		a, b := n.Given[i], other.Given[i]
+		if len([]rune(a)) > 1 && len([]rune(b)) > 1 {
+			if foldName(a) != foldName(b) {
+				return false
+			}
+		} else if n.initial(i) != other.initial(i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// User-provided mapping of author name variants to canonical names,
+// keyed on the folded variant (see foldName)
+type AuthorAliases map[string]string
+
+// Load an author alias file. See loadAliasFile() for its format.
+func LoadAuthorAliases(filename string) (AuthorAliases, error) {
+	aliases, err := loadAliasFile(filename, foldName)
+	return AuthorAliases(aliases), err
+}
+
+// An author identity, and the names it appears under
+type AuthorCluster struct {
+	Name     string   // Canonical display name
+	Variants []string // Names, as they appear in records
+
+	parsed AuthorName // Most complete variant (or alias) of the name
+	pinned bool       // Name was specified via an alias
+}
+
+// Orders name variants such that aliased and more complete names come first
+type byCompleteness []authorVariant
+
+func (v byCompleteness) Len() int      { return len(v) }
+func (v byCompleteness) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byCompleteness) Less(i, j int) bool {
+	if v[i].pinned != v[j].pinned {
+		return v[i].pinned
+	}
+	fi, ni := v[i].parsed.specificity()
+	fj, nj := v[j].parsed.specificity()
+	return fi > fj || (fi == fj && ni > nj)
+}
+
+type byClusterName []AuthorCluster
+
+func (c byClusterName) Len() int      { return len(c) }
+func (c byClusterName) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byClusterName) Less(i, j int) bool {
+	return strings.ToLower(c[i].Name) < strings.ToLower(c[j].Name)
+}
+
+// A name, as it appears in a record
+type authorVariant struct {
+	name      string
+	parsed    AuthorName
+	canonical string // Canonical name, if specified via an alias
+	pinned    bool
+}
+
+type AuthorIndex struct {
+	clusters []*AuthorCluster
+	byName   map[string]*AuthorCluster   // Keyed on variant, as it appears in records
+	byFamily map[string][]*AuthorCluster // Keyed on folded family name
+}
+
+/*
+ * Cluster the provided author names (which may contain duplicates) into
+ * identities, applying any user-provided aliases.
+ */
+func NewAuthorIndex(names []string, aliases AuthorAliases) *AuthorIndex {
+	idx := &AuthorIndex{
+		byName:   make(map[string]*AuthorCluster),
+		byFamily: make(map[string][]*AuthorCluster),
+	}
+
+	groups := make(map[string][]authorVariant)
+	var keys []string
+
+	for _, name := range names {
+		if _, seen := idx.byName[name]; seen || len(strings.TrimSpace(name)) == 0 {
+			continue
+		}
+		idx.byName[name] = nil
+
+		v := authorVariant{name: name}
+		if canonical, have := aliases[foldName(name)]; have {
+			v.parsed = ParseAuthorName(canonical)
+			v.canonical = canonical
+			v.pinned = true
+		} else {
+			v.parsed = ParseAuthorName(name)
+		}
+
+		key := v.parsed.clusterKey()
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], v)
+	}
+
+	for _, key := range keys {
+		group := groups[key]
+
+		// Cluster the most complete variants first, such that less complete
+		// ones (e.g., initials only) may be matched against them
+		sort.Stable(byCompleteness(group))
+
+		var clusters []*AuthorCluster
+		for _, v := range group {
+			var match *AuthorCluster
+			ambiguous := false
+
+			for _, c := range clusters {
+				if c.pinned && v.pinned {
+					if c.Name != v.canonical {
+						continue
+					}
+				} else if !c.parsed.compatible(v.parsed) {
+					continue
+				}
+
+				if match != nil {
+					ambiguous = true
+					break
+				}
+				match = c
+			}
+
+			if match == nil || ambiguous {
+				if ambiguous {
+					Debugf("Author name is ambiguous: %s\n", v.name)
+				}
+				match = &AuthorCluster{Name: v.canonical, parsed: v.parsed, pinned: v.pinned}
+				clusters = append(clusters, match)
+			}
+
+			match.Variants = append(match.Variants, v.name)
+			idx.byName[v.name] = match
+		}
+
+		for _, c := range clusters {
+			if !c.pinned {
+				c.Name = c.parsed.String()
+			}
+			family := foldName(c.parsed.Family)
+			idx.byFamily[family] = append(idx.byFamily[family], c)
+			idx.clusters = append(idx.clusters, c)
+			Verbosef("Author cluster %s: %s\n", c.Name, strings.Join(c.Variants, " / "))
+		}
+	}
+
+	return idx
+}
+
+// Returns the cluster a name (as it appears in a record) belongs to, or nil
+// if the name was not provided to NewAuthorIndex()
+func (idx *AuthorIndex) Lookup(name string) *AuthorCluster {
+	return idx.byName[name]
+}
+
+// Returns the canonical display name for `name`, or `name` itself if it was
+// not provided to NewAuthorIndex()
+func (idx *AuthorIndex) Canonical(name string) string {
+	if c := idx.Lookup(name); c != nil {
+		return c.Name
+	}
+	return name
+}
+
+// Returns the clusters matching a user-specified name. A family name alone
+// (e.g., "Smith") matches all authors with that family name, while
+// "J. Smith" matches "Smith, John" and "Smith, J. A.", but not "Smith, James".
+func (idx *AuthorIndex) Match(query string) []*AuthorCluster {
+	if c := idx.Lookup(query); c != nil {
+		return []*AuthorCluster{c}
+	}
+
+	var matches []*AuthorCluster
+	name := ParseAuthorName(query)
+
+	for _, c := range idx.byFamily[foldName(name.Family)] {
+		if c.parsed.compatible(name) {
+			matches = append(matches, c)
+		}
+	}
+
+	// Fall back to an exact, case- and punctuation-insensitive match on the
+	// canonical name, for names we fail to parse sensibly
+	if len(matches) == 0 {
+		for _, c := range idx.clusters {
+			if Reduce(c.Name) == Reduce(query) {
+				matches = append(matches, c)
+			}
+		}
+	}
+
+	return matches
+}
+
+// Returns all clusters, sorted by canonical name
+func (idx *AuthorIndex) Clusters() []AuthorCluster {
+	clusters := make([]AuthorCluster, len(idx.clusters))
+	for i, c := range idx.clusters {
+		clusters[i] = *c
+	}
+
+	sort.Sort(byClusterName(clusters))
+
+	return clusters
+}
@@ -0,0 +1,661 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * BibTeX "parsing". This handles the syntax produced by the usual suspects
+ * (JabRef, BibDesk, Zotero's Better BibTeX), along with @string macros and
+ * the common LaTeX accent escapes. As with the other loaders, only the fields
+ * we're particularly interested in are scraped.
+ */
+package reid
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type bibParser struct {
+	data    []rune
+	pos     int
+	line    int
+	baseDir string            // Relative file paths are resolved from here
+	macros  map[string]string // @string definitions, keyed on lowercase name
+	pathMap PathMap
+
+	keepIncomplete bool
+}
+
+// A parsed, but not yet interpreted, BibTeX entry
+type bibEntry struct {
+	Type   string            // Lowercase entry type (e.g., "article")
+	Key    string            // Citation key
+	Line   int               // Line on which the entry begins
+	Fields map[string]string // Raw field values, keyed on lowercase name
+}
+
+var errBibEOF = errors.New("Unexpected end of BibTeX file")
+
+// Standard month abbreviations are predefined macros
+var bibMonths = []string{
+	"jan", "feb", "mar", "apr", "may", "jun",
+	"jul", "aug", "sep", "oct", "nov", "dec",
+}
+
+func newBibParser(filename string) (*bibParser, error) {
+	var p *bibParser = new(bibParser)
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	p.data = []rune(strings.TrimPrefix(string(data), "\ufeff"))
+	p.line = 1
+
+	if p.baseDir, err = filepath.Abs(filepath.Dir(filename)); err != nil {
+		return nil, err
+	}
+
+	p.macros = make(map[string]string, len(bibMonths))
+	for i, month := range bibMonths {
+		p.macros[month] = strconv.Itoa(i + 1)
+	}
+
+	return p, nil
+}
+
+func (p *bibParser) errorf(format string, v ...interface{}) error {
+	return fmt.Errorf("BibTeX syntax error on line %d: %s", p.line, fmt.Sprintf(format, v...))
+}
+
+func (p *bibParser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *bibParser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+func (p *bibParser) next() rune {
+	if p.eof() {
+		return 0
+	}
+
+	r := p.data[p.pos]
+	p.pos++
+	if r == '\n' {
+		p.line++
+	}
+	return r
+}
+
+func (p *bibParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.peek()) {
+		p.next()
+	}
+}
+
+// Read an entry type, field name, or macro name
+func (p *bibParser) readIdent() string {
+	start := p.pos
+	for !p.eof() {
+		r := p.peek()
+		if unicode.IsSpace(r) || strings.ContainsRune(`{}(),=#"%`, r) {
+			break
+		}
+		p.next()
+	}
+	return string(p.data[start:p.pos])
+}
+
+// Read the contents of a {...} group, with the opening brace already consumed.
+// Nested braces are retained; the closing brace is consumed.
+func (p *bibParser) readBraced() (string, error) {
+	var depth int = 1
+	start := p.pos
+
+	for !p.eof() {
+		switch p.next() {
+		case '\\':
+			p.next() // Escaped brace
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return string(p.data[start : p.pos-1]), nil
+			}
+		}
+	}
+
+	return "", errBibEOF
+}
+
+// Read the contents of a "..." string, with the opening quote already consumed.
+// Quotes within braces do not terminate the string.
+func (p *bibParser) readQuoted() (string, error) {
+	var depth int
+	start := p.pos
+
+	for !p.eof() {
+		switch p.next() {
+		case '\\':
+			p.next()
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '"':
+			if depth == 0 {
+				return string(p.data[start : p.pos-1]), nil
+			}
+		}
+	}
+
+	return "", errBibEOF
+}
+
+// Read a field value, which may consist of multiple '#'-concatenated parts
+func (p *bibParser) readValue() (string, error) {
+	var value string
+
+	for {
+		p.skipSpace()
+
+		switch r := p.peek(); {
+		case r == '{':
+			p.next()
+			s, err := p.readBraced()
+			if err != nil {
+				return "", err
+			}
+			value += s
+
+		case r == '"':
+			p.next()
+			s, err := p.readQuoted()
+			if err != nil {
+				return "", err
+			}
+			value += s
+
+		default:
+			ident := p.readIdent()
+			if len(ident) == 0 {
+				Debugf("Empty BibTeX field value on line %d\n", p.line)
+				return value, nil
+			}
+
+			if _, err := strconv.Atoi(ident); err == nil {
+				value += ident
+			} else if macro, defined := p.macros[strings.ToLower(ident)]; defined {
+				value += macro
+			} else {
+				Debugf("Undefined BibTeX macro on line %d: %s\n", p.line, ident)
+			}
+		}
+
+		p.skipSpace()
+		if p.peek() != '#' {
+			return value, nil
+		}
+		p.next()
+	}
+}
+
+// Read the "name = value, ..." list of an entry, up to and including the
+// closing delimiter.
+func (p *bibParser) readFields(entry *bibEntry, close rune) error {
+	for {
+		p.skipSpace()
+		switch p.peek() {
+		case close:
+			p.next()
+			return nil
+		case ',':
+			p.next()
+			continue
+		case 0:
+			return errBibEOF
+		}
+
+		name := strings.ToLower(p.readIdent())
+		if len(name) == 0 {
+			return p.errorf("expected a field name, got '%c'", p.peek())
+		}
+
+		p.skipSpace()
+		if p.next() != '=' {
+			return p.errorf("expected '=' after field \"%s\"", name)
+		}
+
+		value, err := p.readValue()
+		if err != nil {
+			return err
+		}
+
+		entry.Fields[name] = value
+	}
+}
+
+// Returns the next entry, or nil at the end of the file.
+// @string definitions are processed internally, and @comment and @preamble
+// blocks are skipped.
+func (p *bibParser) nextEntry() (*bibEntry, error) {
+	for {
+		// Anything outside of an entry is a comment
+		for !p.eof() && p.peek() != '@' {
+			p.next()
+		}
+
+		if p.eof() {
+			return nil, nil
+		}
+
+		p.next()
+		entry := bibEntry{Line: p.line, Fields: make(map[string]string)}
+		entry.Type = strings.ToLower(p.readIdent())
+
+		p.skipSpace()
+		var close rune
+		switch p.next() {
+		case '{':
+			close = '}'
+		case '(':
+			close = ')'
+		default:
+			Debugf("Ignoring stray '@' on line %d\n", entry.Line)
+			continue
+		}
+
+		switch entry.Type {
+		case "comment", "preamble":
+			if close == '}' {
+				if _, err := p.readBraced(); err != nil {
+					return nil, err
+				}
+			} else {
+				for !p.eof() && p.next() != close {
+				}
+			}
+
+		case "string":
+			if err := p.readFields(&entry, close); err != nil {
+				return nil, err
+			}
+
+			for name, value := range entry.Fields {
+				Verbosef("Defined BibTeX macro %s = \"%s\"\n", name, value)
+				p.macros[name] = value
+			}
+
+		default:
+			p.skipSpace()
+			start := p.pos
+			for !p.eof() && p.peek() != ',' && p.peek() != close {
+				p.next()
+			}
+			entry.Key = strings.TrimSpace(string(p.data[start:p.pos]))
+
+			if err := p.readFields(&entry, close); err != nil {
+				return nil, err
+			}
+
+			return &entry, nil
+		}
+	}
+}
+
+var reBibAnd = regexp.MustCompile(`(?i)\s+and\s+`)
+var reBibKeywordSep = regexp.MustCompile(`[,;]`)
+
+// Split an author list on "and", except where it appears within braces
+// (e.g., corporate authors such as "{Barnes and Noble}")
+func splitBibAuthors(s string) []string {
+	var authors []string
+	var depth, start int
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ' ', '\t', '\r', '\n':
+			if depth != 0 {
+				continue
+			}
+
+			if loc := reBibAnd.FindStringIndex(s[i:]); loc != nil && loc[0] == 0 {
+				authors = append(authors, s[start:i])
+				start = i + loc[1]
+				i = start - 1
+			}
+		}
+	}
+	authors = append(authors, s[start:])
+
+	var ret []string
+	for _, author := range authors {
+		author = latexToUnicode(author)
+		if len(author) != 0 && strings.ToLower(author) != "others" {
+			ret = append(ret, author)
+		}
+	}
+	return ret
+}
+
+// Split `s` on `sep`, unless it is escaped with a backslash.
+// Escape sequences are retained for a later unescapeBibPath() call.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	var start int
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == sep {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var bibPathEscapes = strings.NewReplacer(`\:`, ":", `\;`, ";", `\\`, `\`)
+
+func unescapeBibPath(s string) string {
+	return strings.TrimSpace(bibPathEscapes.Replace(s))
+}
+
+// Resolve a "file" field into paths of PDF files. This supports the plain list
+// of paths used by Better BibTeX, as well as the JabRef/Mendeley
+// "description:path:type" syntax. Multiple files are separated by ';'.
+func (p *bibParser) filePaths(field string) []string {
+	var pdfs []string
+
+	for _, file := range splitUnescaped(field, ';') {
+		var path, fileType string
+
+		parts := splitUnescaped(strings.TrimSpace(file), ':')
+		switch {
+		case len(parts) >= 3:
+			// Unescaped colons (e.g., Windows drive letters) belong to the path
+			path = strings.Join(parts[1:len(parts)-1], ":")
+			fileType = parts[len(parts)-1]
+
+		case len(parts) == 2 && len(parts[0]) == 1:
+			path = strings.Join(parts, ":") // Drive letter
+
+		case len(parts) == 2:
+			path, fileType = parts[0], parts[1]
+
+		default:
+			path = parts[0]
+		}
+
+		path = unescapeBibPath(path)
+		if len(path) == 0 {
+			continue
+		}
+
+		fileType = strings.ToLower(unescapeBibPath(fileType))
+		if !strings.Contains(fileType, "pdf") && !(len(fileType) == 0 && isPDF(path)) {
+			Verbosef("Ignoring non-PDF file: %s\n", path)
+			continue
+		}
+
+		if pdf, local := localFilePath(path, p.baseDir, p.pathMap); local {
+			pdfs = append(pdfs, pdf)
+		} else {
+			Debugf("Ignoring non-local file link: %s\n", path)
+		}
+	}
+
+	return pdfs
+}
+
+func (p *bibParser) loadRecord(e *bibEntry) *Record {
+	var rec Record
+
+	Verbosef("Processing @%s{%s}\n", e.Type, e.Key)
+
+	rec.Title = latexToUnicode(e.Fields["title"])
+
+	for _, field := range []string{"journal", "journaltitle", "booktitle"} {
+		if pub := latexToUnicode(e.Fields[field]); len(pub) != 0 {
+			rec.Publication = pub
+			break
+		}
+	}
+
+	// biblatex
+	rec.addAltPublication(latexToUnicode(e.Fields["shortjournal"]))
+
+	// BibLaTeX uses "date" (e.g., 2017-03-01) in place of year and month
+	for _, field := range []string{"year", "date"} {
+		if year := reYear.FindString(e.Fields[field]); len(year) != 0 {
+			rec.Year, _ = strconv.Atoi(year)
+			break
+		}
+	}
+
+	if authors, have := e.Fields["author"]; have {
+		rec.Authors = splitBibAuthors(authors)
+	}
+
+	for _, field := range []string{"language", "langid"} {
+		if lang := latexToUnicode(e.Fields[field]); len(lang) != 0 {
+			rec.Language = lang
+			break
+		}
+	}
+
+	rec.RefType = e.Type
+	rec.DOI = normalizeDOI(e.Fields["doi"])
+	rec.Abstract = latexToUnicode(e.Fields["abstract"])
+	rec.Volume = latexToUnicode(e.Fields["volume"])
+	rec.Issue = latexToUnicode(e.Fields["number"])
+	rec.Pages = strings.Replace(latexToUnicode(e.Fields["pages"]), "--", "-", -1)
+
+	for _, keyword := range reBibKeywordSep.Split(e.Fields["keywords"], -1) {
+		if keyword = latexToUnicode(keyword); len(keyword) != 0 {
+			rec.Keywords = append(rec.Keywords, keyword)
+		}
+	}
+
+	if file, have := e.Fields["file"]; have {
+		rec.PDFs = p.filePaths(file)
+	}
+
+	if url := strings.TrimSpace(e.Fields["url"]); len(url) != 0 {
+		rec.URLs = append(rec.URLs, url)
+	}
+
+	// BibDesk
+	if url, have := e.Fields["local-url"]; have {
+		if pdf, local := localFilePath(url, p.baseDir, p.pathMap); local {
+			rec.PDFs = append(rec.PDFs, pdf)
+		}
+	}
+
+	if accept, _ := acceptRecord(&rec, p.keepIncomplete); !accept {
+		return nil
+	}
+
+	return &rec
+}
+
+func LoadRecordsFromBibTeX(filename string, config LoadConfig) ([]Record, error) {
+	var records RecordSet = NewRecordSet(recSetCapacity)
+
+	p, err := newBibParser(filename)
+	if err != nil {
+		return []Record{}, err
+	}
+	p.pathMap = config.PathMap
+	p.keepIncomplete = config.KeepIncomplete
+
+	lowerLangs(config.Languages)
+
+	for {
+		entry, err := p.nextEntry()
+		if err != nil {
+			return []Record{}, err
+		} else if entry == nil {
+			return records.Values, nil
+		}
+
+		if rec := p.loadRecord(entry); rec != nil {
+			insertRecord(&records, rec, config.Languages)
+		}
+	}
+}
+
+/*
+ * LaTeX escapes
+ */
+
+// Pairs of (base, accented) characters for each accent command
+var latexAccents = map[string]string{
+	`"`: "aäeëiïoöuüyÿAÄEËIÏOÖUÜYŸ",
+	`'`: "aáeéiíoóuúyýcćnńsśzźAÁEÉIÍOÓUÚYÝCĆNŃSŚZŹ",
+	"`": "aàeèiìoòuùAÀEÈIÌOÒUÙ",
+	`^`: "aâeêiîoôuûAÂEÊIÎOÔUÛ",
+	`~`: "aãnñoõAÃNÑOÕ",
+	`=`: "aāeēiīoōuūAĀEĒIĪOŌUŪ",
+	`.`: "zżeėZŻEĖ",
+	`c`: "cçsşCÇSŞ",
+	`v`: "cčsšzžrřeěnňCČSŠZŽRŘEĚNŇ",
+	`H`: "oőuűOŐUŰ",
+	`k`: "aąeęAĄEĘ",
+	`r`: "aåuůAÅUŮ",
+	`u`: "aăgğAĂGĞ",
+}
+
+// Combining characters, used for combinations not listed above
+var latexCombining = map[string]rune{
+	`"`: '\u0308', `'`: '\u0301', "`": '\u0300', `^`: '\u0302',
+	`~`: '\u0303', `=`: '\u0304', `.`: '\u0307', `c`: '\u0327',
+	`v`: '\u030c', `H`: '\u030b', `k`: '\u0328', `r`: '\u030a',
+	`u`: '\u0306',
+}
+
+var latexSymbols = map[string]string{
+	"aa": "å", "AA": "Å", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ",
+	"o": "ø", "O": "Ø", "ss": "ß", "l": "ł", "L": "Ł", "i": "ı", "j": "ȷ",
+	"&": "&", "%": "%", "$": "$", "#": "#", "_": "_", "{": "{", "}": "}",
+	"\\": " ", " ": " ",
+}
+
+func accentChar(accent string, base rune) string {
+	pairs := []rune(latexAccents[accent])
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] == base {
+			return string(pairs[i+1])
+		}
+	}
+	return string([]rune{base, latexCombining[accent]})
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// Convert LaTeX accent escapes and symbols to Unicode, drop any other
+// commands (e.g., \emph) and grouping braces, and collapse whitespace.
+func latexToUnicode(s string) string {
+	var out []rune
+	in := []rune(s)
+
+	// Read a control sequence name, starting just after a backslash
+	readCmd := func(i int) (string, int) {
+		if i >= len(in) {
+			return "", i
+		}
+		if !isLetter(in[i]) {
+			return string(in[i]), i + 1
+		}
+		start := i
+		for i < len(in) && isLetter(in[i]) {
+			i++
+		}
+		return string(in[start:i]), i
+	}
+
+	for i := 0; i < len(in); {
+		switch r := in[i]; r {
+		case '\\':
+			cmd, j := readCmd(i + 1)
+			i = j
+
+			if _, isAccent := latexCombining[cmd]; isAccent && i < len(in) {
+				if isLetter(rune(cmd[0])) {
+					for i < len(in) && in[i] == ' ' {
+						i++
+					}
+				}
+
+				// Argument may be a single character or a braced group,
+				// and may be a dotless i or j (e.g., \'{\i})
+				braced := i < len(in) && in[i] == '{'
+				if braced {
+					i++
+				}
+
+				var base rune
+				if i+1 < len(in) && in[i] == '\\' && (in[i+1] == 'i' || in[i+1] == 'j') {
+					base = in[i+1]
+					i += 2
+				} else if i < len(in) {
+					base = in[i]
+					i++
+				}
+
+				if braced {
+					for i < len(in) && in[i] != '}' {
+						i++
+					}
+					i++
+				}
+
+				if base != 0 {
+					out = append(out, []rune(accentChar(cmd, base))...)
+				}
+			} else {
+				if sym, isSym := latexSymbols[cmd]; isSym {
+					out = append(out, []rune(sym)...)
+				}
+
+				// TeX swallows the whitespace following a control word
+				if len(cmd) != 0 && isLetter(rune(cmd[0])) {
+					for i < len(in) && in[i] == ' ' {
+						i++
+					}
+				}
+			}
+
+		case '{', '}':
+			i++
+
+		case '~':
+			out = append(out, ' ')
+			i++
+
+		default:
+			out = append(out, r)
+			i++
+		}
+	}
+
+	return strings.Join(strings.Fields(string(out)), " ")
+}
@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Conversion provenance
+ *
+ * When a PDF is converted, how it was converted (searchable text or OCR),
+ * when, with which tools, and what it yielded is recorded with its entry,
+ * along with a checksum of the PDF. This allows entries whose PDFs have
+ * changed since they were converted (i.e., are stale) to be identified.
+ */
+
+package reid
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Methods by which a PDF may have been converted
+const (
+	ConversionText    = "pdftotext" // Extraction of searchable text
+	ConversionOCR     = "ocr"       // OCR of the PDF's images
+	ConversionUnknown = "unknown"   // Converted prior to recording this
+)
+
+// How, and from what, a minified text file was produced
+type ConversionInfo struct {
+	PDF         string            // pdfKey() of the PDF, e.g. "123/paper.pdf"
+	Method      string            // One of the Conversion* constants
+	ConvertedAt time.Time         // When the PDF was converted
+	Tools       map[string]string `json:",omitempty"` // Versions of the tools used
+	Pages       int               // Number of pages in the PDF (0 if unknown)
+	Chars       int               // Characters of minified text produced
+
+	PDFSize    int64     // Size of the PDF, in bytes
+	PDFModTime time.Time // Modification time of the PDF
+	PDFMD5     string    // Checksum of the PDF's contents
+}
+
+func (c ConversionInfo) String() string {
+	s := fmt.Sprintf("%s: %s, %s, %d pages, %d characters",
+		c.PDF, c.Method, c.ConvertedAt.Format("2006-01-02 15:04"), c.Pages, c.Chars)
+
+	var tools []string
+	for _, name := range []string{"pdftotext", "pdfimages", "tesseract"} {
+		if version, found := c.Tools[name]; found {
+			tools = append(tools, version)
+		}
+	}
+
+	if len(tools) != 0 {
+		s += " (" + strings.Join(tools, ", ") + ")"
+	}
+
+	return s
+}
+
+// Tool versions, determined once per process
+var toolVersions = make(map[string]string)
+
+// Returns the first line of a tool's version information, as reported via
+// the specified flag. Many tools print this to stderr.
+func toolVersion(tool, flag string) string {
+	if version, found := toolVersions[tool]; found {
+		return version
+	}
+
+	var version string
+	output, _ := exec.Command(tool, flag).CombinedOutput()
+	if lines := strings.SplitN(strings.TrimSpace(string(output)), "\n", 2); len(lines[0]) != 0 {
+		version = strings.TrimSpace(lines[0])
+	}
+
+	toolVersions[tool] = version
+	return version
+}
+
+// Returns the versions of the tools used by the conversion method
+func conversionTools(method string) map[string]string {
+	var flags = map[string]string{"pdftotext": "-v"}
+	if method == ConversionOCR {
+		flags = map[string]string{"pdfimages": "-v", "tesseract": "--version"}
+	}
+
+	tools := make(map[string]string, len(flags))
+	for tool, flag := range flags {
+		if version := toolVersion(tool, flag); len(version) != 0 {
+			tools[tool] = version
+		}
+	}
+	return tools
+}
+
+// Record the PDF's size, modification time, and checksum
+func (c *ConversionInfo) setPDF(filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	c.PDF = pdfKey(filename)
+	c.PDFSize = info.Size()
+	c.PDFModTime = info.ModTime()
+	c.PDFMD5, err = fileMD5(filename)
+	return err
+}
+
+// Describe the conversion of a PDF to `chars` characters of minified text
+func newConversionInfo(filename, method string, chars int) ConversionInfo {
+	c := ConversionInfo{
+		Method:      method,
+		ConvertedAt: time.Now(),
+		Chars:       chars,
+	}
+
+	if method != ConversionUnknown {
+		c.Tools = conversionTools(method)
+	}
+
+	if info, err := pdfInfo(filename); err == nil {
+		c.Pages, _ = strconv.Atoi(info["Pages"])
+	}
+
+	if err := c.setPDF(filename); err != nil {
+		Warnf("Failed to record checksum of %s: %s\n", filename, err)
+	}
+
+	return c
+}
+
+// Returns the reason the PDF has changed since it was converted, or an empty
+// string if it has not. The checksum is only computed if the PDF's size or
+// modification time differ.
+func (c *ConversionInfo) changed(filename string) string {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "" // Can't tell
+	}
+
+	if info.Size() == c.PDFSize && info.ModTime().Equal(c.PDFModTime) {
+		return ""
+	}
+
+	if len(c.PDFMD5) == 0 {
+		return "PDF modified since conversion"
+	}
+
+	if sum, err := fileMD5(filename); err == nil && sum != c.PDFMD5 {
+		return "PDF contents changed since conversion"
+	}
+
+	return ""
+}
+
+// Returns the entry's conversion information for a PDF, or nil if none is
+// recorded
+func (e *ProjectEntry) conversion(pdf string) *ConversionInfo {
+	key := pdfKey(pdf)
+	for i := range e.Conversions {
+		if e.Conversions[i].PDF == key {
+			return &e.Conversions[i]
+		}
+	}
+	return nil
+}
+
+// Record (or replace) the conversion information for one of the entry's PDFs
+func (e *ProjectEntry) setConversion(c ConversionInfo) {
+	if existing := e.conversion(c.PDF); existing != nil {
+		*existing = c
+		return
+	}
+	e.Conversions = append(e.Conversions, c)
+}
+
+// Forget the entry's minified text files, so that it will be converted again
+func (e *ProjectEntry) clearConversion() {
+	e.MiniFiles = []string{}
+	e.Conversions = nil
+}
+
+// Discard conversion information for PDFs the entry no longer has
+func (e *ProjectEntry) pruneConversions() {
+	var kept []ConversionInfo
+	for _, c := range e.Conversions {
+		for _, pdf := range e.Record.PDFs {
+			if pdfKey(pdf) == c.PDF {
+				kept = append(kept, c)
+				break
+			}
+		}
+	}
+	e.Conversions = kept
+}
+
+// Returns whether a converted entry's minified text is stale, and why
+// (see staleReason)
+func (p *Project) IsStale(e *ProjectEntry) (bool, string) {
+	file, reason := p.staleReason(e)
+	if len(reason) == 0 {
+		return false, ""
+	} else if len(file) != 0 {
+		reason = file + ": " + reason
+	}
+
+	return true, reason
+}
+
+// Returns the entries whose minified text is stale (see IsStale)
+func (p *Project) StaleEntries() pEntryList {
+	var stale pEntryList
+	for i := range p.Entries {
+		if isStale, _ := p.IsStale(&p.Entries[i]); isStale {
+			stale = append(stale, &p.Entries[i])
+		}
+	}
+	return stale
+}
+
+/*
+ * Reconvert the specified entries (or all entries, if `records` is empty)
+ * whose minified text is stale. The project file is saved.
+ *
+ * Returns the number of entries that were stale.
+ */
+func (p *Project) ConvertStale(records []RecordToConvert, forceOCR bool) (int, error) {
+	var firstError error
+	var n int
+
+	entries, err := p.selectEntries(records)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		isStale, reason := p.IsStale(e)
+		if !isStale {
+			continue
+		}
+
+		Infof("Reconverting stale entry (%s): %s\n", reason, e.Record.String())
+		n++
+
+		if err := p.convert(e, forceOCR, true); err != nil && firstError == nil {
+			firstError = err
+		}
+	}
+
+	Verbosef("Saving project file: %s\n", p.filename)
+	if err := p.Save(p.filename); err != nil && firstError == nil {
+		firstError = err
+	}
+
+	return n, firstError
+}
+
+// Describe how each of an entry's PDFs was converted
+func (e *ProjectEntry) prettyConversions(eol string) string {
+	var b bytes.Buffer
+	for _, c := range e.Conversions {
+		fmt.Fprintf(&b, "Converted:     %s%s", c.String(), eol)
+	}
+	return b.String()
+}
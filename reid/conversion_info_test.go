@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Tests of conversion provenance and stale entry detection
+ */
+
+package reid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var changedTests = []struct {
+	name     string
+	stamped  bool   // Whether the PDF's size, time and checksum were recorded
+	noMD5    bool   // Whether the checksum was not recorded
+	contents string // New contents of the PDF, if modified
+	keepTime bool   // Restore the PDF's modification time after modifying it
+	touch    bool   // Change the PDF's modification time
+	remove   bool   // Remove the PDF
+	expect   string
+}{
+	{name: "unchanged", stamped: true},
+	{name: "touched", stamped: true, touch: true},
+	{name: "size changed", stamped: true, contents: "%PDF modified", expect: "PDF contents changed since conversion"},
+	{name: "contents changed", stamped: true, contents: "%PDF-1.X", touch: true, expect: "PDF contents changed since conversion"},
+
+	// The checksum is only computed when the size or time differ
+	{name: "contents changed, time kept", stamped: true, contents: "%PDF-1.X", keepTime: true},
+
+	{name: "no checksum, touched", stamped: true, noMD5: true, touch: true, expect: "PDF modified since conversion"},
+	{name: "no checksum, unchanged", stamped: true, noMD5: true},
+	{name: "not stamped", expect: "PDF modified since conversion"},
+	{name: "PDF missing", stamped: true, remove: true},
+}
+
+func TestConversionChanged(t *testing.T) {
+	for _, test := range changedTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			pdf := writeTestFile(t, dir, "PDF/1/a.pdf", "%PDF-1.4")
+			earlier := time.Now().Add(-time.Hour)
+			if err = os.Chtimes(pdf, earlier, earlier); err != nil {
+				t.Fatal(err)
+			}
+
+			c := ConversionInfo{PDF: pdfKey(pdf), Method: ConversionUnknown}
+			if test.stamped {
+				if err = c.setPDF(pdf); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.noMD5 {
+				c.PDFMD5 = ""
+			}
+
+			if len(test.contents) != 0 {
+				writeTestFile(t, dir, "PDF/1/a.pdf", test.contents)
+				if test.keepTime {
+					os.Chtimes(pdf, earlier, earlier)
+				}
+			}
+
+			if test.touch {
+				later := earlier.Add(time.Minute)
+				os.Chtimes(pdf, later, later)
+			}
+
+			if test.remove {
+				os.Remove(pdf)
+			}
+
+			if reason := c.changed(pdf); reason != test.expect {
+				t.Errorf("Got \"%s\", expected \"%s\"", reason, test.expect)
+			}
+		})
+	}
+}
+
+// Existing minified text is recorded as converted from the PDF only if it is
+// not older than the PDF
+func TestConvertExistingMiniFile(t *testing.T) {
+	var existingTests = []struct {
+		name    string
+		age     time.Duration // Age of the minified text, relative to the PDF
+		stamped bool
+		stale   bool
+	}{
+		{"newer", -time.Minute, true, false},
+		{"same time", 0, true, false},
+		{"older", time.Minute, false, true},
+	}
+
+	for _, test := range existingTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			record := testRecord("A", 0, "")
+			record.PDFs = []string{writeTestFile(t, dir, "PDF/1/a.pdf", "%PDF-1.4")}
+
+			p, err := NewProject(filepath.Join(dir, "data"), []Record{record})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e := &p.Entries[0]
+			miniFile := writeTestFile(t, p.DataDir, "1/a.pdf.txt", fsckText)
+
+			pdfTime := time.Now().Add(-time.Hour)
+			miniTime := pdfTime.Add(-test.age)
+			os.Chtimes(e.Record.PDFs[0], pdfTime, pdfTime)
+			os.Chtimes(miniFile, miniTime, miniTime)
+
+			got, err := p.convertPDF(e.Record.PDFs[0], e, false, false)
+			if err != nil {
+				t.Fatal(err)
+			} else if got != miniFile {
+				t.Fatalf("Got %s, expected %s", got, miniFile)
+			}
+			e.MiniFiles = []string{got}
+
+			c := e.conversion(e.Record.PDFs[0])
+			if c == nil {
+				t.Fatal("Conversion was not recorded")
+			} else if c.Method != ConversionUnknown || c.Chars != len(fsckText) {
+				t.Errorf("Unexpected conversion: %s", c.String())
+			}
+
+			if stamped := len(c.PDFMD5) != 0; stamped != test.stamped {
+				t.Errorf("Checksum recorded: got %v, expected %v", stamped, test.stamped)
+			}
+
+			if stale, reason := p.IsStale(e); stale != test.stale {
+				t.Errorf("Stale: got %v (%s), expected %v", stale, reason, test.stale)
+			}
+		})
+	}
+}
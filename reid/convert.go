@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/otiai10/gosseract/v1/gosseract"
@@ -46,6 +47,8 @@ func (r *RecordToConvert) String() string {
  * If the `forceConversion` flag is specified, this will force specified
  * (including when "all" is implicitly by a zero-length `records`) PDFs to be
  * converted, even if a minified text file is already present.
+ *
+ * The project file is saved.
  */
 func (p *Project) Convert(records []RecordToConvert, forceOCR, forceConversion bool) error {
 	if len(records) != 0 {
@@ -53,6 +56,10 @@ func (p *Project) Convert(records []RecordToConvert, forceOCR, forceConversion b
 	} else {
 		var firstError error
 		for i, _ := range p.Entries {
+			if p.Entries[i].Record.IsIncomplete() || !p.tagFilter.Matches(&p.Entries[i]) {
+				continue
+			}
+
 			err := p.convert(&p.Entries[i], forceOCR, forceConversion)
 			if err != nil && firstError == nil {
 				firstError = err
@@ -85,6 +92,13 @@ func (p *Project) convertSubset(records []RecordToConvert, forceOCR, forceConver
 		}
 	}
 
+	// Update project file
+	Verbosef("Saving project file: %s\n", p.filename)
+	err = p.Save(p.filename)
+	if err != nil && firstError == nil {
+		firstError = err
+	}
+
 	return firstError
 }
 
@@ -145,19 +159,15 @@ func (p *Project) aggregateConversionList(records []RecordToConvert) (pEntryList
 		}
 
 		if len(record.Author) != 0 {
-			author, err := NewReducedStr(record.Author)
-			if err != nil {
-				return pEntryList{}, nil
-			} else if convSet.insert(p.authMap[author.Reduced]) {
+			if entries := p.entriesByAuthor(record.Author); len(entries) != 0 {
+				convSet.insert(entries)
 				continue
 			}
 		}
 
 		if len(record.Publication) != 0 {
-			pub, err := NewReducedStr(record.Publication)
-			if err != nil {
-				return pEntryList{}, nil
-			} else if convSet.insert(p.pubMap[pub.Reduced]) {
+			if entries := p.entriesByPublication(record.Publication); len(entries) != 0 {
+				convSet.insert(entries)
 				continue
 			}
 		}
@@ -181,10 +191,16 @@ func (p *Project) aggregateConversionList(records []RecordToConvert) (pEntryList
 			fmt.Errorf("Could not locate a record matching: %s\n", record.String())
 	}
 
-	return convSet.entries, nil
+	return p.tagFilter.filter(convSet.entries), nil
 }
 
 func (p *Project) convert(e *ProjectEntry, forceOCR, overwrite bool) error {
+	if e.Record.IsIncomplete() {
+		Warnf("Not converting incomplete entry (%s): %s\n",
+			strings.Join(e.Record.Status, ", "), e.Record.String())
+		return nil
+	}
+
 	var firstError error
 	var miniFiles []string = make([]string, 0, len(e.Record.PDFs))
 	for _, pdf := range e.Record.PDFs {
@@ -205,19 +221,35 @@ func (p *Project) convert(e *ProjectEntry, forceOCR, overwrite bool) error {
 			Debugf("Successfully converted: %s\n", miniFiles)
 		}
 		e.MiniFiles = miniFiles
+		e.pruneConversions()
 		Verbosef("Updated entry's MiniFiles: %s\n", e.MiniFiles)
 	}
 	return firstError
 }
 
-func pdfToImages(filename string) (string, error) {
+// Append -f/-l page range arguments, as accepted by the poppler utilities.
+// A `last` page of 0 denotes the end of the document.
+func pageArgs(args []string, first, last int) []string {
+	if first > 0 {
+		args = append(args, "-f", strconv.Itoa(first))
+	}
+	if last > 0 {
+		args = append(args, "-l", strconv.Itoa(last))
+	}
+	return args
+}
+
+// Extract the images from (a range of pages of) a PDF into a temporary
+// directory, which the caller is responsible for removing.
+func pdfToImages(filename string, first, last int) (string, error) {
 	tmpDir, err := ioutil.TempDir("/tmp", "reid-convert-")
 	if err != nil {
 		return "", err
 	}
 
 	pfx := tmpDir + "/img"
-	err = exec.Command("pdfimages", filename, pfx).Run()
+	args := append(pageArgs([]string{}, first, last), filename, pfx)
+	err = exec.Command("pdfimages", args...).Run()
 	if err != nil {
 		os.RemoveAll(tmpDir)
 		return "", err
@@ -262,18 +294,36 @@ func imagesToText(dir string) (string, error) {
 	return text, nil
 }
 
+// Extract searchable text from (a range of pages of) a PDF
+func pdfToText(filename string, first, last int) (string, error) {
+	args := pageArgs([]string{"-q", "-nopgbrk", "-enc", "UTF-8", "-eol", "unix"}, first, last)
+	output, err := exec.Command("pdftotext", append(args, filename, "-")...).Output()
+	return string(output), err
+}
+
+// Extract text from (a range of pages of) a PDF using OCR
+func pdfToTextOCR(filename string, first, last int) (string, error) {
+	imgDir, err := pdfToImages(filename, first, last)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(imgDir)
+
+	return imagesToText(imgDir)
+}
+
 // First try converting via searchable text. If this yields an empty
 // file, we probably have a PDF that's scanned images -- attempt to use OCR.
 //
-// Returns minified output and error status
-func (p *Project) convertAndMinify(filename string, forceOCR bool) ([]byte, error) {
+// Returns minified output, the conversion method used, and error status
+func (p *Project) convertAndMinify(filename string, forceOCR bool) ([]byte, string, error) {
 	if !forceOCR {
-		output, err := exec.Command("pdftotext", "-q", "-nopgbrk", "-enc", "UTF-8", "-eol", "unix", filename, "-").Output()
+		output, err := pdfToText(filename, 0, 0)
 		if err != nil {
-			return []byte{}, err
+			return []byte{}, "", err
 		}
 
-		minText := minify(string(output))
+		minText := minify(output)
 		textLen := len(minText)
 		if textLen == 0 {
 			Debug("PDF did not contain searchable text. Using OCR conversion.")
@@ -281,23 +331,17 @@ func (p *Project) convertAndMinify(filename string, forceOCR bool) ([]byte, erro
 			Debugf("Conversion yielded suspiciously low character count (%d). Trying OCR instead...\n", textLen)
 		} else {
 			Verbosef("Collected %d characters of searchable text.\n", textLen)
-			return minText, nil
+			return minText, ConversionText, nil
 		}
 
 	}
 
-	imgDir, err := pdfToImages(filename)
-	if err != nil {
-		return []byte{}, err
-	}
-	defer os.RemoveAll(imgDir)
-
-	text, err := imagesToText(imgDir)
+	text, err := pdfToTextOCR(filename, 0, 0)
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, "", err
 	}
 
-	return minify(text), nil
+	return minify(text), ConversionOCR, nil
 }
 
 // Returns MiniFiles entry path, error
@@ -316,18 +360,37 @@ func (p *Project) convertPDF(filename string, e *ProjectEntry, forceOCR, overwri
 	}
 
 	// Only overwrite the file if requested
-	if _, err := os.Stat(miniFile); !os.IsNotExist(err) && !overwrite {
+	if info, err := os.Stat(miniFile); !os.IsNotExist(err) && !overwrite {
 		Debugf("%s already exists and an overwrite wasn't requested.\n", miniFile)
+
+		// If it is not older than the PDF, presumably it was converted from
+		// the PDF as it is now. Otherwise, the PDF is not recorded, such that
+		// the entry is reported as stale (see IsStale).
+		if err == nil && e.conversion(filename) == nil {
+			var c ConversionInfo
+			if pdfStat, err := os.Stat(filename); err == nil && !info.ModTime().Before(pdfStat.ModTime()) {
+				c = newConversionInfo(filename, ConversionUnknown, int(info.Size()))
+			} else {
+				c = ConversionInfo{PDF: pdfKey(filename), Method: ConversionUnknown, Chars: int(info.Size())}
+			}
+			c.ConvertedAt = info.ModTime()
+			e.setConversion(c)
+		}
 		return miniFile, nil
 	}
 
 	// Convert PDF->txt and minify it
-	text, err := p.convertAndMinify(filename, forceOCR)
+	text, method, err := p.convertAndMinify(filename, forceOCR)
 	if err != nil {
 		return "", err
 	}
 
-	return miniFile, ioutil.WriteFile(miniFile, text, 0640)
+	if err = ioutil.WriteFile(miniFile, text, 0640); err != nil {
+		return "", err
+	}
+
+	e.setConversion(newConversionInfo(filename, method, len(text)))
+	return miniFile, nil
 }
 
 func minify(text string) []byte {
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Summary of how much of a library or project can actually be searched
+ */
+
+package reid
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Coverage struct {
+	Records    int            // Total number of records (or project entries)
+	Complete   int            // Records with full text and complete metadata
+	Converted  int            // Complete project entries with converted text
+	Incomplete map[string]int // Number of incomplete records, by Status
+}
+
+func RecordCoverage(records []Record) Coverage {
+	var c Coverage = Coverage{Incomplete: make(map[string]int)}
+
+	for i := range records {
+		c.add(&records[i])
+	}
+
+	return c
+}
+
+func (c *Coverage) add(r *Record) {
+	c.Records++
+
+	if !r.IsIncomplete() {
+		c.Complete++
+	}
+
+	for _, status := range r.Status {
+		c.Incomplete[status]++
+	}
+}
+
+func (p *Project) Coverage() Coverage {
+	var c Coverage = Coverage{Incomplete: make(map[string]int)}
+
+	for i := range p.Entries {
+		c.add(&p.Entries[i].Record)
+		if !p.Entries[i].Record.IsIncomplete() && len(p.Entries[i].MiniFiles) != 0 {
+			c.Converted++
+		}
+	}
+
+	return c
+}
+
+// Returns the percentage of `n` relative to the total number of records
+func (c Coverage) percent(n int) float64 {
+	if c.Records == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(c.Records)
+}
+
+// A record may have more than one Status, so the incomplete counts
+// will not necessarily sum to (Records - Complete).
+func (c Coverage) Pretty(eol string, project bool) string {
+	var s string
+
+	s = fmt.Sprintf("Records:    %d%s", c.Records, eol)
+	s += fmt.Sprintf("Complete:   %d (%.1f%%)%s", c.Complete, c.percent(c.Complete), eol)
+	if project {
+		s += fmt.Sprintf("Converted:  %d (%.1f%%)%s", c.Converted, c.percent(c.Converted), eol)
+	}
+
+	if len(c.Incomplete) != 0 {
+		statuses := make([]string, 0, len(c.Incomplete))
+		for status := range c.Incomplete {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+
+		s += fmt.Sprintf("Incomplete: %d (%.1f%%)%s",
+			c.Records-c.Complete, c.percent(c.Records-c.Complete), eol)
+
+		for _, status := range statuses {
+			s += fmt.Sprintf("   %-20s %d%s", status+":", c.Incomplete[status], eol)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * CSL-JSON loading (e.g., from Zotero or Mendeley exports)
+ *
+ * CSL-JSON does not carry paths to attachments, so PDFs are located by
+ * searching a caller-specified directory. See cslPDFIndex.find().
+ */
+package reid
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type cslName struct {
+	Family  string `json:"family"`
+	Given   string `json:"given"`
+	Literal string `json:"literal"`
+}
+
+type cslDate struct {
+	DateParts [][]interface{} `json:"date-parts"`
+	Raw       string          `json:"raw"`
+	Literal   string          `json:"literal"`
+}
+
+// Only the fields we're interested in are listed here
+type cslItem struct {
+	ID             json.RawMessage `json:"id"` // May be a string or number
+	CitationKey    string          `json:"citation-key"`
+	Title          string          `json:"title"`
+	ContainerTitle string          `json:"container-title"`
+	ContainerShort string          `json:"container-title-short"`
+	JournalAbbr    string          `json:"journalAbbreviation"`
+	Author         []cslName       `json:"author"`
+	Issued         cslDate         `json:"issued"`
+	Language       string          `json:"language"`
+	Type           string          `json:"type"`
+	DOI            string          `json:"DOI"`
+	Abstract       string          `json:"abstract"`
+	Keyword        string          `json:"keyword"` // Comma-separated
+	Volume         cslString       `json:"volume"`
+	Issue          cslString       `json:"issue"`
+	Page           cslString       `json:"page"`
+	URL            string          `json:"URL"`
+}
+
+// Some "string" fields are frequently emitted as numbers
+type cslString string
+
+func (s *cslString) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = cslString(str)
+		return nil
+	}
+
+	var num json.Number
+	if err := json.Unmarshal(data, &num); err != nil {
+		return err
+	}
+	*s = cslString(num.String())
+	return nil
+}
+
+// Names are formatted as "Family, Given" to match what EndNote produces
+func (n *cslName) String() string {
+	if len(n.Literal) != 0 {
+		return n.Literal
+	} else if len(n.Given) == 0 {
+		return n.Family
+	} else if len(n.Family) == 0 {
+		return n.Given
+	}
+	return n.Family + ", " + n.Given
+}
+
+func (d *cslDate) year() int {
+	if len(d.DateParts) != 0 && len(d.DateParts[0]) != 0 {
+		// Year may be encoded as either a number or a string
+		if year, err := strconv.Atoi(fmt.Sprint(d.DateParts[0][0])); err == nil {
+			return year
+		}
+	}
+
+	for _, s := range []string{d.Raw, d.Literal} {
+		if year := reYear.FindString(s); len(year) != 0 {
+			year, _ := strconv.Atoi(year)
+			return year
+		}
+	}
+
+	return 0
+}
+
+func (i *cslItem) id() string {
+	var s string
+	if err := json.Unmarshal(i.ID, &s); err == nil {
+		return s
+	}
+	return string(i.ID)
+}
+
+// Minimum length of a reduced title for which we'll accept a PDF whose
+// filename merely contains the title. Shorter titles must match exactly.
+const cslMinTitleMatchLen = 16
+
+// Index of the PDFs available under the attachment directory
+type cslPDFIndex struct {
+	byName map[string][]string // Keyed on reduced filename, sans extension
+	byDir  map[string][]string // Keyed on reduced parent directory name
+}
+
+func newCSLPDFIndex(dir string) (*cslPDFIndex, error) {
+	var idx *cslPDFIndex = new(cslPDFIndex)
+	idx.byName = make(map[string][]string)
+	idx.byDir = make(map[string][]string)
+
+	if len(dir) == 0 {
+		return idx, nil
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	walk := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if info.IsDir() || !isPDF(path) {
+			return nil
+		}
+
+		name := Reduce(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		idx.byName[name] = append(idx.byName[name], path)
+
+		parent := Reduce(filepath.Base(filepath.Dir(path)))
+		idx.byDir[parent] = append(idx.byDir[parent], path)
+
+		return nil
+	}
+
+	if err := filepath.Walk(dir, walk); err != nil {
+		return nil, err
+	}
+
+	Debugf("Found %d PDF filenames in %s\n", len(idx.byName), dir)
+	return idx, nil
+}
+
+// Locate PDFs for an item, trying the following (first match wins):
+//  1. A file or directory named after the citation key or item ID
+//  2. A file named after the item's title (case and punctuation insensitive)
+//  3. A file whose name contains the item's title (e.g., "Smith - 2017 - Title.pdf")
+func (idx *cslPDFIndex) find(item *cslItem) []string {
+	for _, key := range []string{item.CitationKey, item.id()} {
+		if key = Reduce(key); len(key) == 0 {
+			continue
+		} else if pdfs := idx.byName[key]; len(pdfs) != 0 {
+			return pdfs
+		} else if pdfs := idx.byDir[key]; len(pdfs) != 0 {
+			return pdfs
+		}
+	}
+
+	title := Reduce(item.Title)
+	if len(title) == 0 {
+		return nil
+	} else if pdfs := idx.byName[title]; len(pdfs) != 0 {
+		return pdfs
+	} else if len(title) < cslMinTitleMatchLen {
+		return nil
+	}
+
+	var pdfs []string
+	for name, paths := range idx.byName {
+		if strings.Contains(name, title) {
+			pdfs = append(pdfs, paths...)
+		}
+	}
+
+	// Map iteration order is random, but PDF lists are compared in order
+	// (see samePDFs)
+	sort.Strings(pdfs)
+	return pdfs
+}
+
+/*
+ * Load records from a CSL-JSON file. PDFs are located by searching
+ * config.PDFDir, which may be empty if no attachments are available.
+ */
+func LoadRecordsFromCSLJSON(filename string, config LoadConfig) ([]Record, error) {
+	var records RecordSet = NewRecordSet(recSetCapacity)
+	var items []cslItem
+
+	infile, err := os.Open(filename)
+	if err != nil {
+		return []Record{}, err
+	}
+	defer infile.Close()
+
+	if err = json.NewDecoder(infile).Decode(&items); err != nil {
+		return []Record{}, err
+	}
+
+	pdfIndex, err := newCSLPDFIndex(config.PDFDir)
+	if err != nil {
+		return []Record{}, err
+	}
+
+	lowerLangs(config.Languages)
+
+	for i, _ := range items {
+		item := &items[i]
+		Verbosef("Processing item %s\n", item.id())
+
+		rec := Record{
+			Title:       strings.TrimSpace(item.Title),
+			Publication: strings.TrimSpace(item.ContainerTitle),
+			Year:        item.Issued.year(),
+			Language:    item.Language,
+			PDFs:        pdfIndex.find(item),
+			RefType:     item.Type,
+			DOI:         normalizeDOI(item.DOI),
+			Abstract:    item.Abstract,
+			Volume:      string(item.Volume),
+			Issue:       string(item.Issue),
+			Pages:       string(item.Page),
+		}
+
+		if len(item.URL) != 0 {
+			rec.URLs = []string{item.URL}
+		}
+
+		rec.addAltPublication(item.ContainerShort)
+		rec.addAltPublication(item.JournalAbbr)
+
+		for _, keyword := range strings.Split(item.Keyword, ",") {
+			if keyword = strings.TrimSpace(keyword); len(keyword) != 0 {
+				rec.Keywords = append(rec.Keywords, keyword)
+			}
+		}
+
+		for _, author := range item.Author {
+			if name := strings.TrimSpace(author.String()); len(name) != 0 {
+				rec.Authors = append(rec.Authors, name)
+			}
+		}
+
+		if accept, _ := acceptRecord(&rec, config.KeepIncomplete); !accept {
+			continue
+		}
+
+		insertRecord(&records, &rec, config.Languages)
+	}
+
+	return records.Values, nil
+}
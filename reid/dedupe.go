@@ -0,0 +1,414 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Fuzzy duplicate detection
+ *
+ * RecordSet only catches duplicates whose metadata hashes match exactly.
+ * This identifies likely duplicates that differ slightly (e.g., typos in
+ * titles, differing numbers of authors) based upon title similarity, DOIs,
+ * and the contents of their PDFs.
+ */
+
+package reid
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type DedupeConfig struct {
+	MinConfidence float64 // Minimum confidence of reported matches (0.0 - 1.0)
+	ComparePDFs   bool    // Compare PDFs' contents (slow for large libraries)
+}
+
+const DefaultDedupeConfidence = 0.85
+
+// A set of records that are likely duplicates of one another
+type DuplicateCluster struct {
+	Members    []int    // Indices of the records in the cluster
+	Keep       int      // Index of the record suggested to keep
+	Confidence float64  // Lowest confidence of the matches forming the cluster
+	Reasons    []string // Why the records were matched
+}
+
+// Character bigrams of a reduced string, with their counts
+type bigrams map[string]int
+
+func newBigrams(s string) bigrams {
+	b := make(bigrams)
+	r := []rune(Reduce(s))
+	for i := 0; i+1 < len(r); i++ {
+		b[string(r[i:i+2])]++
+	}
+	return b
+}
+
+func (b bigrams) len() int {
+	var n int
+	for _, count := range b {
+		n += count
+	}
+	return n
+}
+
+// Dice coefficient of two bigram sets: 1.0 if identical, 0.0 if disjoint
+func (b bigrams) similarity(other bigrams) float64 {
+	total := b.len() + other.len()
+	if total == 0 {
+		return 0
+	}
+
+	var shared int
+	for gram, count := range b {
+		if otherCount := other[gram]; otherCount < count {
+			shared += otherCount
+		} else {
+			shared += count
+		}
+	}
+
+	return 2 * float64(shared) / float64(total)
+}
+
+// Compute the MD5 of a file's contents
+func fileMD5(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Union-find over record indices, tracking the weakest link in each set
+type dupSets struct {
+	parent     []int
+	confidence map[int]float64
+	reasons    map[int]StringSet
+	dois       map[int]string // DOI of the records in a set, if any
+}
+
+func (s *dupSets) find(i int) int {
+	for s.parent[i] != i {
+		s.parent[i] = s.parent[s.parent[i]]
+		i = s.parent[i]
+	}
+	return i
+}
+
+// Returns true if the sets containing a and b have different DOIs, in
+// which case they describe different works
+func (s *dupSets) conflict(a, b int) bool {
+	da, db := s.dois[s.find(a)], s.dois[s.find(b)]
+	return len(da) != 0 && len(db) != 0 && da != db
+}
+
+func (s *dupSets) union(a, b int, confidence float64, reason string) {
+	ra, rb := s.find(a), s.find(b)
+
+	if doi := s.dois[rb]; len(doi) != 0 {
+		s.dois[ra] = doi
+	}
+
+	minConf := confidence
+	for _, r := range []int{ra, rb} {
+		if c, have := s.confidence[r]; have && c < minConf {
+			minConf = c
+		}
+	}
+
+	reasons := NewStringSet(4)
+	for _, r := range []int{ra, rb} {
+		if set, have := s.reasons[r]; have {
+			for _, value := range set.Values {
+				reasons.Insert(value)
+			}
+		}
+	}
+	reasons.Insert(reason)
+
+	if ra != rb {
+		s.parent[rb] = ra
+		delete(s.confidence, rb)
+		delete(s.reasons, rb)
+	}
+
+	s.confidence[ra] = minConf
+	s.reasons[ra] = reasons
+}
+
+// Family name of a record's first author
+func firstAuthorFamily(r *Record) string {
+	if len(r.Authors) == 0 {
+		return ""
+	}
+	return foldName(ParseAuthorName(r.Authors[0]).Family)
+}
+
+// Score the likelihood that two records with similar titles are duplicates.
+// Returns the confidence and a description of the match.
+func scoreTitleMatch(a, b *Record, sim float64) (float64, string) {
+	confidence := sim
+	reason := fmt.Sprintf("title similarity %.2f", sim)
+
+	if a.Year != 0 && b.Year != 0 && a.Year != b.Year {
+		confidence *= 0.95
+		reason += ", different years"
+	}
+
+	if fa, fb := firstAuthorFamily(a), firstAuthorFamily(b); len(fa) != 0 && len(fb) != 0 && fa != fb {
+		confidence *= 0.8
+		reason += ", different first authors"
+	}
+
+	if len(a.Authors) != len(b.Authors) {
+		reason += ", different author counts"
+	}
+
+	return confidence, reason
+}
+
+/*
+ * Identify clusters of likely duplicate records. Records are matched by:
+ *	- Equal DOIs
+ *	- Identical PDF contents (if config.ComparePDFs is set)
+ *	- Similar titles, with the confidence reduced if years or first authors
+ *	  differ. Only records published within a year of each other (or lacking
+ *	  a year) are compared.
+ *
+ * Records with differing DOIs are never placed in the same cluster.
+ *
+ * Clusters are returned in order of their first member.
+ */
+func FindDuplicates(records []Record, config DedupeConfig) []DuplicateCluster {
+	sets := dupSets{
+		parent:     make([]int, len(records)),
+		confidence: make(map[int]float64),
+		reasons:    make(map[int]StringSet),
+		dois:       make(map[int]string),
+	}
+	for i := range sets.parent {
+		sets.parent[i] = i
+		sets.dois[i] = strings.ToLower(records[i].DOI)
+	}
+
+	// Equal DOIs
+	byDOI := make(map[string]int)
+	for i := range records {
+		if doi := strings.ToLower(records[i].DOI); len(doi) != 0 {
+			if j, exists := byDOI[doi]; exists {
+				sets.union(j, i, 1.0, "same DOI")
+			} else {
+				byDOI[doi] = i
+			}
+		}
+	}
+
+	// Identical PDFs
+	if config.ComparePDFs {
+		byMD5 := make(map[string]int)
+		for i := range records {
+			for _, pdf := range records[i].PDFs {
+				sum, err := fileMD5(pdf)
+				if err != nil {
+					Debugf("Failed to hash %s: %s\n", pdf, err)
+					continue
+				}
+
+				if j, exists := byMD5[sum]; exists && j != i && !sets.conflict(i, j) {
+					sets.union(j, i, 1.0, "identical PDF")
+				} else {
+					byMD5[sum] = i
+				}
+			}
+		}
+	}
+
+	// Similar titles, blocked by year
+	titles := make([]bigrams, len(records))
+	byYear := make(map[int][]int)
+	var noYear []int
+	for i := range records {
+		titles[i] = newBigrams(records[i].Title)
+		if records[i].Year > 0 {
+			byYear[records[i].Year] = append(byYear[records[i].Year], i)
+		} else {
+			noYear = append(noYear, i)
+		}
+	}
+
+	compare := func(i, j int) {
+		a, b := &records[i], &records[j]
+		if sets.conflict(i, j) {
+			return
+		}
+
+		sim := titles[i].similarity(titles[j])
+		if sim < config.MinConfidence {
+			return
+		}
+
+		if confidence, reason := scoreTitleMatch(a, b, sim); confidence >= config.MinConfidence {
+			Debugf("Possible duplicates (%.2f): %s / %s\n", confidence, a, b)
+			sets.union(i, j, confidence, reason)
+		}
+	}
+
+	for i := range records {
+		year := records[i].Year
+		if year <= 0 {
+			// Records lacking a year are compared against those with
+			// one when the latter are visited.
+			for _, j := range noYear {
+				if j > i {
+					compare(i, j)
+				}
+			}
+			continue
+		}
+
+		for _, j := range byYear[year] {
+			if j > i {
+				compare(i, j)
+			}
+		}
+
+		for _, j := range byYear[year+1] {
+			compare(i, j)
+		}
+
+		for _, j := range noYear {
+			compare(i, j)
+		}
+	}
+
+	// Collect clusters
+	var clusters []DuplicateCluster
+	byRoot := make(map[int]int)
+	for i := range records {
+		root := sets.find(i)
+		if _, matched := sets.reasons[root]; !matched {
+			continue // Not a member of any cluster
+		}
+
+		c, exists := byRoot[root]
+		if !exists {
+			c = len(clusters)
+			byRoot[root] = c
+			clusters = append(clusters, DuplicateCluster{
+				Confidence: sets.confidence[root],
+				Reasons:    sets.reasons[root].Values,
+			})
+		}
+		clusters[c].Members = append(clusters[c].Members, i)
+	}
+
+	for i := range clusters {
+		clusters[i].Keep = preferredRecord(records, clusters[i].Members)
+	}
+
+	return clusters
+}
+
+// A rough measure of how complete a record is
+func recordCompleteness(r *Record) int {
+	var n int
+	for _, present := range []bool{
+		len(r.PDFs) != 0, len(r.DOI) != 0, len(r.Publication) != 0, r.Year > 0,
+		len(r.Authors) != 0, len(r.Volume) != 0, len(r.Pages) != 0,
+		len(r.Abstract) != 0, !r.IsIncomplete(),
+	} {
+		if present {
+			n++
+		}
+	}
+	return n + len(r.Authors)
+}
+
+// Select the most complete record, preferring those listed first
+func preferredRecord(records []Record, members []int) int {
+	keep := members[0]
+	for _, i := range members[1:] {
+		if recordCompleteness(&records[i]) > recordCompleteness(&records[keep]) {
+			keep = i
+		}
+	}
+	return keep
+}
+
+// Describe the cluster, marking the record suggested to keep with a '*'
+func (c DuplicateCluster) Pretty(records []Record, eol string) string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "%d records, confidence %.2f (%s)%s",
+		len(c.Members), c.Confidence, strings.Join(c.Reasons, "; "), eol)
+
+	for _, i := range c.Members {
+		mark := " "
+		if i == c.Keep {
+			mark = "*"
+		}
+		fmt.Fprintf(&b, "  %s %s [%s]%s", mark, records[i].String(), records[i].IdentityString(), eol)
+	}
+
+	return b.String()
+}
+
+// Returns the indices of records that are not the preferred record of
+// their cluster, in ascending order
+func DuplicatesToRemove(clusters []DuplicateCluster) []int {
+	var remove []int
+	for _, c := range clusters {
+		for _, i := range c.Members {
+			if i != c.Keep {
+				remove = append(remove, i)
+			}
+		}
+	}
+	sort.Ints(remove)
+	return remove
+}
+
+/*
+ * Identify clusters of likely duplicate project entries. Indices refer to
+ * p.Entries. Entries with converted text are preferred over those without.
+ */
+func (p *Project) FindDuplicates(config DedupeConfig) []DuplicateCluster {
+	records := make([]Record, len(p.Entries))
+	for i := range p.Entries {
+		records[i] = p.Entries[i].Record
+		if config.ComparePDFs {
+			records[i].PDFs = make([]string, len(p.Entries[i].Record.PDFs))
+			for j, pdf := range p.Entries[i].Record.PDFs {
+				if local, found := p.findPDF(pdf); found {
+					pdf = local
+				}
+				records[i].PDFs[j] = pdf
+			}
+		}
+	}
+
+	clusters := FindDuplicates(records, config)
+	for c := range clusters {
+		for _, i := range clusters[c].Members {
+			keep := clusters[c].Keep
+			if len(p.Entries[i].MiniFiles) != 0 && len(p.Entries[keep].MiniFiles) == 0 {
+				clusters[c].Keep = i
+			}
+		}
+	}
+
+	return clusters
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Load records directly from an EndNote (X8 and later) .enl library, which is
+ * an SQLite database. Attached PDFs live in the <library>.Data/PDF directory
+ * alongside it, using the same layout referenced by internal-pdf:// URLs in
+ * exported XML files.
+ */
+package reid
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// References that have been moved to the trash have a non-zero trash_state
+const enlRefQuery = `
+SELECT id, title, secondary_title, alternate_title, year, language, author
+FROM refs
+WHERE trash_state = 0
+ORDER BY id;`
+
+const enlFileQuery = `
+SELECT refs_id, file_path
+FROM file_res
+ORDER BY refs_id, file_pos;`
+
+// Authors are stored in a single column, one per line
+var reEnlAuthorSep = regexp.MustCompile(`[\r\n]+`)
+
+// Columns of enlRefQuery
+const (
+	enlColID = iota
+	enlColTitle
+	enlColSecondaryTitle
+	enlColAltTitle
+	enlColYear
+	enlColLanguage
+	enlColAuthor
+	enlNumCols
+)
+
+func loadEnlRecord(row []string, pdfs []string, keepIncomplete bool) *Record {
+	var rec Record
+
+	// This is the record number included in exported XML (rec-number)
+	rec.RecNumber, _ = strconv.Atoi(strings.TrimSpace(row[enlColID]))
+	rec.Title = strings.TrimSpace(row[enlColTitle])
+	rec.Language = strings.TrimSpace(row[enlColLanguage])
+	rec.PDFs = pdfs
+
+	// As with the XML, assume the longest of these is the publication title
+	for _, col := range []int{enlColSecondaryTitle, enlColAltTitle} {
+		rec.addPublication(row[col])
+	}
+
+	if year := reYear.FindString(row[enlColYear]); len(year) != 0 {
+		rec.Year, _ = strconv.Atoi(year)
+	}
+
+	for _, author := range reEnlAuthorSep.Split(row[enlColAuthor], -1) {
+		if author = strings.TrimSpace(author); len(author) != 0 {
+			rec.Authors = append(rec.Authors, author)
+		}
+	}
+
+	if accept, _ := acceptRecord(&rec, keepIncomplete); !accept {
+		return nil
+	}
+
+	return &rec
+}
+
+func LoadRecordsFromEnl(filename string, config LoadConfig) ([]Record, error) {
+	var records RecordSet = NewRecordSet(recSetCapacity)
+
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return []Record{}, err
+	}
+	dbPath := libraryDBPath(filepath.Dir(absFilename), filepath.Base(absFilename))
+
+	Verbose("Loading file attachments")
+	fileRows, err := sqliteQuery(filename, enlFileQuery)
+	if err != nil {
+		return []Record{}, err
+	}
+
+	pdfs := make(map[string][]string, len(fileRows))
+	for _, row := range fileRows {
+		if len(row) != 2 {
+			Debugf("Ignoring malformed file_res row: %q\n", row)
+		} else if isPDF(row[1]) {
+			pdfs[row[0]] = append(pdfs[row[0]], libraryPDFPath(dbPath, row[1]))
+		} else {
+			Verbosef("Ignoring non-PDF attachment: %s\n", row[1])
+		}
+	}
+
+	Verbose("Loading references")
+	refRows, err := sqliteQuery(filename, enlRefQuery)
+	if err != nil {
+		return []Record{}, err
+	}
+
+	lowerLangs(config.Languages)
+
+	for _, row := range refRows {
+		if len(row) != enlNumCols {
+			Debugf("Ignoring malformed refs row: %q\n", row)
+			continue
+		}
+
+		Verbosef("Processing reference %s\n", row[enlColID])
+		if rec := loadEnlRecord(row, pdfs[row[enlColID]], config.KeepIncomplete); rec != nil {
+			insertRecord(&records, rec, config.Languages)
+		}
+	}
+
+	return records.Values, nil
+}
@@ -9,8 +9,10 @@
 package reid
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/xml"
-	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"os"
@@ -20,8 +22,43 @@ import (
 )
 
 type xmlLoader struct {
-	file io.ReadCloser
-	dec  *xml.Decoder
+	file    io.ReadCloser
+	reader  *lineReader
+	dec     *xml.Decoder
+	pathMap PathMap
+
+	keepIncomplete bool
+
+	report    *ImportReport
+	recLine   int   // Line number of the current record
+	recOffset int64 // Byte offset of the current record
+}
+
+// Tracks the line number of the data consumed by the XML decoder.
+//
+// Because this implements io.ByteReader, the decoder does not wrap it in its
+// own buffered reader, and therefore does not read ahead of its position.
+type lineReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{r: bufio.NewReader(r), line: 1}
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.line += bytes.Count(p[:n], []byte{'\n'})
+	return n, err
+}
+
+func (r *lineReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil && b == '\n' {
+		r.line++
+	}
+	return b, err
 }
 
 func newXmlLoader(filename string) (*xmlLoader, error) {
@@ -33,7 +70,8 @@ func newXmlLoader(filename string) (*xmlLoader, error) {
 		return nil, err
 	}
 
-	l.dec = xml.NewDecoder(l.file)
+	l.reader = newLineReader(l.file)
+	l.dec = xml.NewDecoder(l.reader)
 	return l, nil
 }
 
@@ -72,36 +110,81 @@ func (l *xmlLoader) seekToEndElt(name string) error {
 	return l.seekToElt(name, false, true)
 }
 
-// Skip everything until the end of the current record
-func (l *xmlLoader) skipRecord() error {
-	if err := l.seekToEndElt("record"); err != nil {
-		return err
-	} else {
-		return errors.New("Skipped record due to error")
+// Read and discard data until the specified start tag is found within the
+// current `parent` element. Returns false if the end of `parent` is reached
+// first. Both `name` and `parent` are assumed to be lowercase.
+func (l *xmlLoader) seekToChildElt(name, parent string) (bool, error) {
+	for {
+		tok, err := l.dec.Token()
+		if err != nil {
+			return false, err
+		}
+
+		switch elt := tok.(type) {
+		case xml.StartElement:
+			if name == strings.ToLower(elt.Name.Local) {
+				return true, nil
+			}
+
+		case xml.EndElement:
+			if parent == strings.ToLower(elt.Name.Local) {
+				return false, nil
+			}
+		}
 	}
 }
 
-func (l *xmlLoader) loadRecordDatabase(elt xml.StartElement) (string, error) {
+// Record an issue with the current record in the import report
+func (l *xmlLoader) reportIssue(rec *Record, reason, detail string) {
+	l.report.add(ImportIssue{
+		RecNumber: rec.RecNumber,
+		Title:     rec.Title,
+		Line:      l.recLine,
+		Offset:    l.recOffset,
+		Reason:    reason,
+		Detail:    detail,
+	})
+}
+
+// Returns an empty string if the database path or name is not present, in
+// which case any internal-pdf:// links in the record cannot be resolved.
+func (l *xmlLoader) loadRecordDatabase(elt xml.StartElement) string {
 	var path, name string
 
 	Verbose("Processing database")
 	for _, attr := range elt.Attr {
 		if strings.ToLower(attr.Name.Local) == "path" {
-			path = filepath.Dir(attr.Value)
+			// The path is to the library file, as seen by the machine the XML
+			// was exported on. (Possibly Windows, using '\' separators.)
+			path = filepath.Dir(normalizeSeparators(l.pathMap.Translate(attr.Value)))
 		} else if strings.ToLower(attr.Name.Local) == "name" {
 			name = attr.Value
 		}
 	}
 
 	if len(path) == 0 {
-		Debug("No database path in the current record. Skipping the rest of this record.")
-		return "", l.skipRecord()
+		Debug("No database path in the current record.")
+		return ""
 	} else if len(name) == 0 {
-		Debug("No database name in the current record. Skipping the rest of this record.")
-		return "", l.skipRecord()
+		Debug("No database name in the current record.")
+		return ""
 	}
 
-	return filepath.Join(path, strings.Replace(name, ".enl", "", -1)), nil
+	return libraryDBPath(path, name)
+}
+
+// Returns the path to an EndNote library, sans .enl extension
+func libraryDBPath(dir, name string) string {
+	return filepath.Join(dir, strings.Replace(name, ".enl", "", -1))
+}
+
+// Returns the path to a PDF stored within an EndNote library's .Data directory,
+// given the path returned by libraryDBPath() and the PDF's "internal" path.
+//
+// The case of the .Data directory name has been observed to vary (e.g.,
+// ".DATA"), so the on-disk directory is matched case-insensitively.
+func libraryPDFPath(dbPath, pdf string) string {
+	return filepath.Join(resolvePathCase(filepath.Join(dbPath+".Data", "PDF")), pdf)
 }
 
 func (l *xmlLoader) readDataString(currElt string) (string, error) {
@@ -110,7 +193,7 @@ func (l *xmlLoader) readDataString(currElt string) (string, error) {
 	for {
 		tok, err := l.dec.Token()
 		if err != nil {
-			return "", nil
+			return "", err
 		}
 
 		switch elt := tok.(type) {
@@ -124,18 +207,6 @@ func (l *xmlLoader) readDataString(currElt string) (string, error) {
 	}
 }
 
-func (l *xmlLoader) readDataInt(currElt string, min, max int) (int, error) {
-	if s, err := l.readDataString(currElt); err != nil {
-		return -1, err
-	} else if val, err := strconv.Atoi(s); err != nil {
-		return -1, err
-	} else if val < min || val > max {
-		return -1, errors.New("Integer outside of valid range")
-	} else {
-		return val, nil
-	}
-}
-
 func (l *xmlLoader) readDataStrings(parent, target string) ([]string, error) {
 	var strs []string
 	var name string
@@ -210,9 +281,7 @@ func (l *xmlLoader) loadRecordTitles(r *Record) error {
 				return err
 			}
 
-			if len(pub) > len(r.Publication) {
-				r.Publication = pub
-			}
+			r.addPublication(pub)
 
 		default:
 			continue
@@ -220,11 +289,138 @@ func (l *xmlLoader) loadRecordTitles(r *Record) error {
 	}
 }
 
+// Collect the full and abbreviated titles listed in a <periodical> or
+// <alt-periodical> element as alternate publication titles
+func (l *xmlLoader) loadRecordPeriodical(r *Record, parent string) error {
+	Verbosef("Processing %s\n", parent)
+	for {
+		tok, err := l.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch elt := tok.(type) {
+		case xml.StartElement:
+			switch name := strings.ToLower(elt.Name.Local); name {
+			case "full-title", "abbr-1", "abbr-2", "abbr-3":
+				title, err := l.readDataString(name)
+				if err != nil {
+					return err
+				}
+				r.addAltPublication(title)
+			}
+
+		case xml.EndElement:
+			if strings.ToLower(elt.Name.Local) == parent {
+				return nil
+			}
+		}
+	}
+}
+
+// A URL listed in a record, along with the <urls> child it was listed under
+// (e.g., "pdf-urls", "web-urls", "related-urls")
+type recordURL struct {
+	group string
+	url   string
+}
+
+func (l *xmlLoader) loadRecordURLs() ([]recordURL, error) {
+	var urls []recordURL
+	var group string
+
+	Verbose("Processing urls")
+	for {
+		tok, err := l.dec.Token()
+		if err != nil {
+			return urls, err
+		}
+
+		switch elt := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(elt.Name.Local)
+			if name != "url" {
+				group = name
+				continue
+			}
+
+			s, err := l.readDataString(name)
+			if err != nil {
+				return urls, err
+			}
+			urls = append(urls, recordURL{group: group, url: strings.TrimSpace(s)})
+
+		case xml.EndElement:
+			if strings.ToLower(elt.Name.Local) == "urls" {
+				return urls, nil
+			}
+		}
+	}
+}
+
+// Convert an internal-pdf:// link to a path within the library's .Data directory
+func internalPDFPath(dbPath, link string) (string, error) {
+	pdf := strings.Replace(link, internalPDFScheme, "", 1)
+
+	// QueryUnescape will turn '+' in filenames into spaces,
+	// which is not desirable. As hack, we'll replace it with a marker
+	// and then swap it back out...
+	pdf = strings.Replace(pdf, "+", "__REIDPLUS__", -1)
+
+	// These are escaped in the XMLs I've seen so far
+	pdf, err := url.QueryUnescape(pdf)
+	if err != nil {
+		return "", err
+	}
+
+	// Reintroduce those crazy '+' characters...
+	pdf = strings.Replace(pdf, "__REIDPLUS__", "+", -1)
+
+	return libraryPDFPath(dbPath, pdf), nil
+}
+
+const internalPDFScheme = "internal-pdf://"
+
+// Sort a record's URLs into local PDFs and (remote) URLs.
+//
+// Anything listed under <pdf-urls> that refers to a local file is treated as
+// a PDF, as are local files with a .pdf extension listed elsewhere. Relative
+// paths are resolved relative to the directory containing the library.
+func (l *xmlLoader) resolveRecordURLs(rec *Record, dbPath string, urls []recordURL) error {
+	for _, u := range urls {
+		if len(u.url) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(u.url, internalPDFScheme) {
+			if len(dbPath) == 0 {
+				Debugf("No database path available for: %s\n", u.url)
+				continue
+			}
+
+			pdf, err := internalPDFPath(dbPath, u.url)
+			if err != nil {
+				return err
+			}
+			rec.PDFs = append(rec.PDFs, pdf)
+		} else if path, local := localFilePath(u.url, filepath.Dir(dbPath), l.pathMap); !local {
+			rec.URLs = append(rec.URLs, u.url)
+		} else if u.group == "pdf-urls" || isPDF(path) {
+			rec.PDFs = append(rec.PDFs, path)
+		} else {
+			Debugf("Ignoring non-PDF local file: %s\n", path)
+		}
+	}
+
+	return nil
+}
+
 func (l *xmlLoader) loadRecord() (*Record, error) {
 	var rec Record
 
 	var dbPath string
-	var pdfs []string
+	var urls []recordURL
+	var invalidYear string
 
 	var eltName string
 	var startElt xml.StartElement
@@ -244,48 +440,24 @@ func (l *xmlLoader) loadRecord() (*Record, error) {
 		case xml.EndElement:
 			if strings.ToLower(elt.Name.Local) == "record" {
 
-				for _, pdf := range pdfs {
-					// We're only concerned with local PDFS for now
-					// TODO Track other URLS?
-					internal := "internal-pdf://"
-					if strings.HasPrefix(pdf, internal) {
-						pdf = strings.Replace(pdf, internal, "", 1)
-
-						// QueryUnescape will turn '+' in filenames into spaces,
-						// which is not desirable. As hack, we'll replace it with a marker
-						// and then swap it back out...
-						pdf = strings.Replace(pdf, "+", "__REIDPLUS__", -1)
-
-						// These are escaped in the XMLs I've seen so far
-						pdf, err = url.QueryUnescape(pdf)
-						if err != nil {
-							return nil, err
-						}
-
-						// Reintroduce those crazy '+' characters...
-						pdf = strings.Replace(pdf, "__REIDPLUS__", "+", -1)
-
-						rec.PDFs = append(rec.PDFs, filepath.Join(dbPath+".Data", "PDF", pdf))
-					}
-				}
-
-				complete, missing := rec.isComplete()
-				if complete && len(dbPath) == 0 {
-					complete = false
-					missing = "Database path"
+				if err = l.resolveRecordURLs(&rec, dbPath, urls); err != nil {
+					return nil, err
 				}
 
-				// The <library>.DATA directory lives alongside the EndNote library
-				dbPath = strings.Replace(dbPath, ".enl", ".DATA", 1)
-
-				if !complete {
-					if missing == "Title" {
-						Debug("Not including incomplete record - missing Title\n")
-					} else {
-						Debugf("Not including incomplete record \"%s\" - missing %s\n",
-							rec.Title, missing)
-					}
+				// Records with an invalid year are treated as lacking one, such
+				// that they are kept (as incomplete) if requested
+				accept, missing := acceptRecord(&rec, l.keepIncomplete)
+				if !accept && len(invalidYear) != 0 {
+					Debugf("Not including record \"%s\" - invalid year: %s\n",
+						rec.Title, invalidYear)
+					l.reportIssue(&rec, IssueInvalidYear, invalidYear)
+					return nil, nil
+				} else if !accept {
+					l.reportIssue(&rec, incompleteReason(missing), "")
 					return nil, nil
+				} else if len(invalidYear) != 0 {
+					Debugf("Keeping record \"%s\" despite invalid year: %s\n",
+						rec.Title, invalidYear)
 				}
 
 				return &rec, nil
@@ -299,19 +471,19 @@ func (l *xmlLoader) loadRecord() (*Record, error) {
 
 		switch eltName {
 		case "database":
-			dbPath, err = l.loadRecordDatabase(startElt)
+			dbPath = l.loadRecordDatabase(startElt)
 
 		case "contributors":
 			/* TODO I've only seen authors listed in <contributors> so far.
 			 *		What other types of contributors can be present here? */
-			err = l.seekToStartElt("authors")
-			if err != nil {
-				return nil, err
+			var found bool
+			if found, err = l.seekToChildElt("authors", eltName); err == nil && !found {
+				needEndElt = false // No authors; already consumed </contributors>
+			} else if err == nil {
+				Verbose("Processing authors")
+				rec.Authors, err = l.readDataStrings("authors", "author")
 			}
 
-			Verbose("Processing authors")
-			rec.Authors, err = l.readDataStrings("authors", "author")
-
 		case "titles":
 			needEndElt = false
 			err = l.loadRecordTitles(&rec)
@@ -325,19 +497,96 @@ func (l *xmlLoader) loadRecord() (*Record, error) {
 			Verbosef("Processing %s\n", eltName)
 
 			// We only care about the publication year
-			err = l.seekToStartElt("year")
-			if err == nil {
-				rec.Year, err = l.readDataInt("year", 1, 3030)
-				if err != nil {
-					Debug("Invalid date encountered. Skipping the rest of the current record.")
-					err = l.skipRecord()
+			var found bool
+			if found, err = l.seekToChildElt("year", eltName); err == nil && !found {
+				needEndElt = false // No year; already consumed </dates>
+			} else if err == nil {
+				var year string
+				if year, err = l.readDataString("year"); err == nil {
+					year = strings.TrimSpace(year)
+					if rec.Year, err = strconv.Atoi(year); err != nil || rec.Year < 1 || rec.Year > 3030 {
+						Debugf("Invalid year encountered: %s\n", year)
+						invalidYear = year
+						rec.Year, err = 0, nil
+					}
 				}
 			}
 
 		case "urls":
 			Verbosef("Processing %s\n", eltName)
 			needEndElt = false
-			pdfs, err = l.readDataStrings("urls", "url")
+			urls, err = l.loadRecordURLs()
+
+		case "periodical", "alt-periodical":
+			needEndElt = false
+			err = l.loadRecordPeriodical(&rec, eltName)
+
+		case "ref-type":
+			needEndElt = false
+			Verbosef("Processing %s\n", eltName)
+
+			// Prefer the human-readable name over the numeric type
+			for _, attr := range startElt.Attr {
+				if strings.ToLower(attr.Name.Local) == "name" {
+					rec.RefType = attr.Value
+				}
+			}
+
+			var refType string
+			refType, err = l.readDataString(eltName)
+			if len(rec.RefType) == 0 {
+				rec.RefType = strings.TrimSpace(refType)
+			}
+
+		case "rec-number":
+			needEndElt = false
+			Verbosef("Processing %s\n", eltName)
+
+			var recNumber string
+			if recNumber, err = l.readDataString(eltName); err == nil {
+				if rec.RecNumber, err = strconv.Atoi(strings.TrimSpace(recNumber)); err != nil {
+					Debugf("Ignoring invalid record number: %s\n", recNumber)
+					rec.RecNumber, err = 0, nil
+				}
+			}
+
+		case "electronic-resource-num":
+			needEndElt = false
+			Verbosef("Processing %s\n", eltName)
+
+			var doi string
+			doi, err = l.readDataString(eltName)
+			rec.DOI = normalizeDOI(doi)
+
+		case "keywords":
+			needEndElt = false
+			Verbosef("Processing %s\n", eltName)
+			rec.Keywords, err = l.readDataStrings("keywords", "keyword")
+
+		case "abstract", "volume", "number", "pages", "accession-num", "label", "research-notes":
+			needEndElt = false
+			Verbosef("Processing %s\n", eltName)
+
+			var value string
+			value, err = l.readDataString(eltName)
+			value = strings.TrimSpace(value)
+
+			switch eltName {
+			case "abstract":
+				rec.Abstract = value
+			case "volume":
+				rec.Volume = value
+			case "number":
+				rec.Issue = value
+			case "pages":
+				rec.Pages = value
+			case "accession-num":
+				rec.AccessionNum = value
+			case "label":
+				rec.Label = value
+			case "research-notes":
+				rec.ResearchNotes = value
+			}
 
 		default:
 			Verbosef("Ignoring element while processing record: %s\n", eltName)
@@ -357,47 +606,71 @@ func (l *xmlLoader) loadRecord() (*Record, error) {
 // Arbitrary "more than I ever expect to need" pre-allocation
 const recSetCapacity = 10000
 
-func LoadRecordsFromXML(filename string, filterLangs []string) ([]Record, error) {
+/*
+ * Load records from an EndNote XML file. The returned report describes each
+ * record that was not loaded, and why.
+ */
+func LoadRecordsFromXML(filename string, config LoadConfig) ([]Record, *ImportReport, error) {
 	var records RecordSet = NewRecordSet(5000)
+	var report *ImportReport = &ImportReport{Filename: filename}
 	var err error
 
 	l, err := newXmlLoader(filename)
 	if err != nil {
-		return []Record{}, err
+		return []Record{}, report, err
 	}
+	defer l.file.Close()
+	l.pathMap = config.PathMap
+	l.keepIncomplete = config.KeepIncomplete
+	l.report = report
 
 	err = l.seekToStartElt("records")
 	if err != nil {
-		return []Record{}, err
+		return []Record{}, report, err
 	}
 
 	// Perform case-insensitive language comparissons
-	for i := 0; i < len(filterLangs); i++ {
-		filterLangs[i] = strings.ToLower(filterLangs[i])
-	}
+	lowerLangs(config.Languages)
 
 	for {
 		err = l.seekToStartElt("record")
 		if err == io.EOF {
-			return records.Values, nil
+			report.Loaded = len(records.Values)
+			for i := range records.Values {
+				if records.Values[i].IsIncomplete() {
+					report.Incomplete++
+				}
+			}
+			return records.Values, report, nil
 		} else if err != nil {
-			return []Record{}, err
+			return []Record{}, report, err
 		}
 
+		l.recLine = l.reader.line
+		l.recOffset = l.dec.InputOffset()
+		report.Records++
+
 		rec, err := l.loadRecord()
 		if err != nil {
-			return []Record{}, err
-		} else if rec != nil {
-			if rec.IsWrittenIn(filterLangs) {
-				newRecord := records.Insert(rec)
-				if !newRecord {
-					Debug("Not including potential duplicate:", rec)
-				} else {
-					Debug("Loaded record: ", rec)
+			return []Record{}, report,
+				fmt.Errorf("%s: record at line %d: %s", filename, l.recLine, err)
+		} else if rec == nil {
+			continue
+		}
+
+		switch reason := insertRecord(&records, rec, config.Languages); reason {
+		case IssueDuplicate:
+			var detail string
+			if prev, found := records.Get(rec.Hash()); found {
+				detail = fmt.Sprintf("same hash as \"%s\"", prev.Title)
+				if prev.RecNumber != 0 {
+					detail += fmt.Sprintf(", record %d", prev.RecNumber)
 				}
-			} else {
-				Debugf("Not including due to Language=%s: %s\n", rec.Language, rec)
 			}
+			l.reportIssue(rec, reason, detail)
+
+		case IssueLanguage:
+			l.reportIssue(rec, reason, rec.Language)
 		}
 	}
 }
@@ -0,0 +1,428 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Metadata "enrichment" - proposing values for a record's missing fields
+ * based upon the contents of its PDF(s). This is entirely offline; values are
+ * taken from the text of the first few pages and the PDF's document
+ * information dictionary (via pdfinfo).
+ *
+ * Proposals are stored with their project entry until a user accepts them.
+ */
+
+package reid
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of pages from which text is extracted
+const enrichPages = 2
+
+// If fewer than this many characters of searchable text are found, OCR is
+// used instead
+const enrichMinTextLen = 200
+
+// Minimum length of a (reduced) publication name that we'll search for in
+// a PDF's text. Shorter names are too likely to yield false positives.
+const enrichMinPubLen = 12
+
+// Fields for which values may be proposed
+const (
+	FieldDOI         = "DOI"
+	FieldYear        = "Year"
+	FieldPublication = "Publication"
+	FieldAuthors     = "Authors"
+)
+
+// A value proposed for a record field that is currently empty
+type Proposal struct {
+	Field  string // One of the Field* constants
+	Value  string // Proposed value. Authors are separated by "; "
+	Source string // Where the value came from (e.g., "pdfinfo Subject")
+	PDF    string // PDF the value was extracted from
+}
+
+func (p Proposal) String() string {
+	return fmt.Sprintf("%s: %s  [%s]", p.Field, p.Value, p.Source)
+}
+
+var reCopyrightYear = regexp.MustCompile(
+	`(?i)(?:©|\(c\)|copyright|published(?: online)?:?)\s*(?:by\s+)?((?:1[5-9]|20)[0-9]{2})\b`)
+
+// Lines that look like the name of a journal or proceedings
+var reVenueLine = regexp.MustCompile(
+	`(?m)^\s*((?:The )?(?:(?:International |American |European )?Journal of|` +
+		`Proceedings of|(?:IEEE|ACM) Transactions on|Annals of)[^\n0-9,(]{3,100})`)
+
+// Run pdfinfo, returning its fields (e.g., "Title", "Subject", "CreationDate")
+func pdfInfo(filename string) (map[string]string, error) {
+	info := make(map[string]string)
+
+	output, err := exec.Command("pdfinfo", "-enc", "UTF-8", filename).Output()
+	if err != nil {
+		return info, err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, ":"); i > 0 {
+			if value := strings.TrimSpace(line[i+1:]); len(value) != 0 {
+				info[line[:i]] = value
+			}
+		}
+	}
+
+	return info, scanner.Err()
+}
+
+// Returns true if the year is one we'd expect of a publication
+func plausibleYear(year int) bool {
+	return year >= 1500 && year <= time.Now().Year()+1
+}
+
+// Returns true if the specified field of the record is empty
+func (r *Record) fieldIsEmpty(field string) bool {
+	switch field {
+	case FieldDOI:
+		return len(r.DOI) == 0
+	case FieldYear:
+		return r.Year <= 0
+	case FieldPublication:
+		return len(r.Publication) == 0
+	case FieldAuthors:
+		return len(r.Authors) == 0
+	default:
+		return false
+	}
+}
+
+func (r *Record) setField(field, value string) error {
+	switch field {
+	case FieldDOI:
+		r.DOI = value
+	case FieldYear:
+		year, err := strconv.Atoi(value)
+		if err != nil || !plausibleYear(year) {
+			return fmt.Errorf("Invalid year: %s", value)
+		}
+		r.Year = year
+	case FieldPublication:
+		r.Publication = value
+	case FieldAuthors:
+		r.Authors = r.Authors[:0]
+		for _, author := range strings.Split(value, ";") {
+			if author = strings.TrimSpace(author); len(author) != 0 {
+				r.Authors = append(r.Authors, author)
+			}
+		}
+	default:
+		return fmt.Errorf("Unsupported field: %s", field)
+	}
+	return nil
+}
+
+// Returns true if any of the fields we can propose values for are empty
+func (r *Record) canEnrich() bool {
+	for _, field := range []string{FieldDOI, FieldYear, FieldPublication, FieldAuthors} {
+		if r.fieldIsEmpty(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Collects proposals for a single entry, keeping at most one per field/value
+type proposalSet struct {
+	rec       *Record
+	proposals []Proposal
+	have      map[string]bool
+}
+
+func (s *proposalSet) add(field, value, source, pdf string) {
+	value = strings.TrimSpace(value)
+	key := field + "|" + strings.ToLower(value)
+
+	if len(value) == 0 || s.have[key] || !s.rec.fieldIsEmpty(field) {
+		return
+	}
+
+	Debugf("Proposing %s=\"%s\" (%s) for: %s\n", field, value, source, s.rec.Title)
+	s.have[key] = true
+	s.proposals = append(s.proposals, Proposal{
+		Field: field, Value: value, Source: source, PDF: pdf,
+	})
+}
+
+// Propose values based upon the text of a PDF's first pages
+func (p *Project) proposeFromText(s *proposalSet, pdf string, forceOCR bool) error {
+	var text string
+	var err error
+
+	source := fmt.Sprintf("text, pages 1-%d", enrichPages)
+
+	if !forceOCR {
+		if text, err = pdfToText(pdf, 1, enrichPages); err != nil {
+			return err
+		}
+	}
+
+	if len(strings.TrimSpace(text)) < enrichMinTextLen {
+		Debugf("Using OCR to extract text from: %s\n", pdf)
+		source = fmt.Sprintf("OCR, pages 1-%d", enrichPages)
+		if text, err = pdfToTextOCR(pdf, 1, enrichPages); err != nil {
+			return err
+		}
+	}
+
+	if doi := reDOI.FindString(text); len(doi) != 0 {
+		s.add(FieldDOI, normalizeDOI(doi), source, pdf)
+	}
+
+	if m := reCopyrightYear.FindStringSubmatch(text); m != nil {
+		if year, _ := strconv.Atoi(m[1]); plausibleYear(year) {
+			s.add(FieldYear, m[1], source+", copyright/publication notice", pdf)
+		}
+	}
+
+	// Prefer publications already known to the project
+	var known ReducedStr
+	reduced := Reduce(text)
+	for _, pub := range p.pubs {
+		if len(pub.Reduced) >= enrichMinPubLen && len(pub.Reduced) > len(known.Reduced) &&
+			strings.Contains(reduced, pub.Reduced) {
+			known = pub
+		}
+	}
+	if len(known.String) != 0 {
+		s.add(FieldPublication, known.String, source+", known publication", pdf)
+	}
+
+	if m := reVenueLine.FindStringSubmatch(text); m != nil {
+		s.add(FieldPublication, reExtraSpace.ReplaceAllString(m[1], " "), source, pdf)
+	}
+
+	return nil
+}
+
+// Propose values based upon a PDF's document information
+func (p *Project) proposeFromInfo(s *proposalSet, pdf string) error {
+	info, err := pdfInfo(pdf)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range []string{"Subject", "Keywords", "Title"} {
+		if doi := reDOI.FindString(info[key]); len(doi) != 0 {
+			s.add(FieldDOI, normalizeDOI(doi), "pdfinfo "+key, pdf)
+		}
+	}
+
+	// Publishers frequently place the citation in the Subject
+	// (e.g., "Journal of Foo, 12 (2004) 34-56. doi:...")
+	if subject := info["Subject"]; len(subject) != 0 {
+		if i := strings.IndexAny(subject, ",0123456789("); i >= 0 {
+			subject = subject[:i]
+		}
+		if len(Reduce(subject)) >= enrichMinPubLen {
+			s.add(FieldPublication, subject, "pdfinfo Subject", pdf)
+		}
+	}
+
+	if author := info["Author"]; len(author) != 0 {
+		s.add(FieldAuthors, strings.Join(strings.FieldsFunc(author, func(c rune) bool {
+			return c == ';' || c == '\n'
+		}), "; "), "pdfinfo Author", pdf)
+	}
+
+	// The creation date is frequently that of the scan, rather than the
+	// publication, so this is proposed last.
+	for _, key := range []string{"CreationDate", "ModDate"} {
+		if year := reYear.FindString(info[key]); len(year) != 0 {
+			if y, _ := strconv.Atoi(year); plausibleYear(y) {
+				s.add(FieldYear, year, "pdfinfo "+key, pdf)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns whether two lists of proposals are identical
+func sameProposals(a, b []Proposal) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Collect proposals for the empty fields of an entry's record. Returns
+// whether its proposals changed.
+func (p *Project) enrichEntry(e *ProjectEntry, forceOCR bool) (bool, error) {
+	var firstError error
+
+	s := proposalSet{rec: &e.Record, have: make(map[string]bool)}
+	for _, prop := range e.Proposals {
+		s.add(prop.Field, prop.Value, prop.Source, prop.PDF)
+	}
+
+	for _, pdf := range e.Record.PDFs {
+		if _, err := os.Stat(pdf); err != nil {
+			if local, found := p.findPDF(pdf); found {
+				pdf = local
+			} else {
+				Errorf("PDF does not exist: %s\n", pdf)
+				continue
+			}
+		}
+
+		Infof("Extracting metadata from %s\n", pdf)
+
+		if err := p.proposeFromText(&s, pdf, forceOCR); err != nil {
+			Errorf("Failed to extract text from '%s' - %s\n", pdf, err)
+			if firstError == nil {
+				firstError = err
+			}
+		}
+
+		if err := p.proposeFromInfo(&s, pdf); err != nil {
+			Errorf("Failed to read document information from '%s' - %s\n", pdf, err)
+			if firstError == nil {
+				firstError = err
+			}
+		}
+	}
+
+	changed := !sameProposals(e.Proposals, s.proposals)
+	e.Proposals = s.proposals
+	return changed, firstError
+}
+
+// Select the entries specified by `records`, or all entries if it is empty
+func (p *Project) selectEntries(records []RecordToConvert) (pEntryList, error) {
+	if len(records) != 0 {
+		return p.aggregateConversionList(records)
+	}
+
+	entries := make(pEntryList, len(p.Entries))
+	for i := range p.Entries {
+		entries[i] = &p.Entries[i]
+	}
+	return p.tagFilter.filter(entries), nil
+}
+
+/*
+ * Propose values for the empty DOI, Year, Publication, and Authors fields of
+ * the specified entries (or all entries, if `records` is empty), based upon
+ * the contents of their PDFs. Proposals are saved to the project file, if any
+ * were found, and may be accepted via AcceptProposals().
+ *
+ * If the `forceOCR` flag is set, OCR is used to extract text from the PDFs.
+ */
+func (p *Project) Enrich(records []RecordToConvert, forceOCR bool) error {
+	var firstError error
+	var changed bool
+
+	entries, err := p.selectEntries(records)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Record.canEnrich() || len(entry.Record.PDFs) == 0 {
+			continue
+		}
+
+		entryChanged, err := p.enrichEntry(entry, forceOCR)
+		if err != nil && firstError == nil {
+			firstError = err
+		}
+		changed = changed || entryChanged
+	}
+
+	// Avoid needlessly rotating the project file's backups
+	if !changed {
+		Verbosef("No new proposals. Not saving project file: %s\n", p.filename)
+		return firstError
+	}
+
+	Verbosef("Saving project file: %s\n", p.filename)
+	if err := p.Save(p.filename); err != nil && firstError == nil {
+		firstError = err
+	}
+
+	return firstError
+}
+
+/*
+ * Accept the first proposal for each empty field of the specified entries
+ * (or all entries, if `records` is empty). Entries that become complete are
+ * no longer flagged as incomplete. Entries identified by their metadata hash
+ * are assigned a new one, although the former hash may still be used.
+ *
+ * Returns the number of entries that were updated. The project file is saved
+ * only if any proposals were accepted or discarded.
+ */
+func (p *Project) AcceptProposals(records []RecordToConvert) (int, error) {
+	var updated int
+	var discarded bool
+
+	entries, err := p.selectEntries(records)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		var remaining []Proposal
+		changed := false
+
+		for _, prop := range e.Proposals {
+			if !e.Record.fieldIsEmpty(prop.Field) {
+				continue // Already set, or a prior proposal was accepted
+			}
+
+			if err := e.Record.setField(prop.Field, prop.Value); err != nil {
+				Warnf("Not accepting proposal (%s) - %s\n", prop.String(), err)
+				remaining = append(remaining, prop)
+				continue
+			}
+
+			Infof("Accepted %s for: %s\n", prop.String(), e.Record.Title)
+			changed = true
+		}
+
+		discarded = discarded || len(remaining) != len(e.Proposals)
+		e.Proposals = remaining
+		if changed {
+			if e.Record.IsIncomplete() {
+				e.Record.updateStatus()
+			}
+			p.updateIdentity(e)
+			updated++
+		}
+	}
+
+	if !discarded {
+		Verbosef("No proposals accepted. Not saving project file: %s\n", p.filename)
+		return 0, nil
+	}
+
+	Verbosef("Saving project file: %s\n", p.filename)
+	return updated, p.Save(p.filename)
+}
@@ -0,0 +1,420 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Project health checks and repair
+ *
+ * This checks that a project's entries and the contents of its data directory
+ * agree with one another, reporting:
+ *	- Minified text files listed by an entry that no longer exist (dangling)
+ *	- Minified text files in the data directory not listed by any entry
+ *	  (orphans)
+ *	- Minified text files whose PDFs changed since they were converted, or
+ *	  that do not correspond to their entry's PDFs (stale)
+ *	- Suspiciously small minified text files
+ *	- PDFs that cannot be located
+ */
+
+package reid
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// What to do with orphaned minified text files when repairing a project
+const (
+	OrphansKeep   = "keep"   // Report them only
+	OrphansDelete = "delete" // Delete them
+	OrphansAdopt  = "adopt"  // Assign them to unconverted entries whose PDFs they correspond to
+)
+
+type FsckConfig struct {
+	Repair  bool   // Repair the problems found. The project is not saved.
+	Orphans string // One of the Orphans* constants
+
+	// Delete orphans that do not correspond to any of the project's PDFs
+	// (e.g., those of another project sharing the data directory)
+	Force bool
+}
+
+// A problem with a project entry
+type FsckIssue struct {
+	Hash   string // Identifier of the entry
+	Record Record
+	File   string // Minified text file or PDF concerned
+	Detail string
+}
+
+type FsckReport struct {
+	Dangling    []FsckIssue // Listed minified text files that do not exist
+	Stale       []FsckIssue // Minified text files that are out of date
+	Small       []FsckIssue // Suspiciously small minified text files
+	MissingPDFs []FsckIssue // PDFs that could not be located
+	Orphans     []string    // Minified text files not listed by any entry
+	Adopted     []string    // Orphans assigned to entries
+	Deleted     []string    // Files deleted during the repair
+	Skipped     []string    // Orphans not deleted, as they do not correspond to the project's PDFs
+
+	Requeued int // Entries that will be converted by the next reid-convert run
+}
+
+// Returns the number of problems found
+func (r FsckReport) Problems() int {
+	return len(r.Dangling) + len(r.Stale) + len(r.Small) + len(r.MissingPDFs) + len(r.Orphans)
+}
+
+func writeFsckIssues(b *bytes.Buffer, what string, issues []FsckIssue, eol string) {
+	fmt.Fprintf(b, "%s: %d%s", what, len(issues), eol)
+	for _, issue := range issues {
+		fmt.Fprintf(b, "   %s %s%s", issue.Hash, issue.Record.String(), eol)
+		fmt.Fprintf(b, "      %s", issue.File)
+		if len(issue.Detail) != 0 {
+			fmt.Fprintf(b, " (%s)", issue.Detail)
+		}
+		b.WriteString(eol)
+	}
+}
+
+func writeFsckFiles(b *bytes.Buffer, what string, files []string, eol string) {
+	fmt.Fprintf(b, "%s: %d%s", what, len(files), eol)
+	for _, f := range files {
+		fmt.Fprintf(b, "   %s%s", f, eol)
+	}
+}
+
+func (r FsckReport) Pretty(eol string) string {
+	var b bytes.Buffer
+
+	writeFsckIssues(&b, "Missing minified text", r.Dangling, eol)
+	writeFsckIssues(&b, "Stale minified text", r.Stale, eol)
+	writeFsckIssues(&b, "Small minified text", r.Small, eol)
+	writeFsckIssues(&b, "Missing PDFs", r.MissingPDFs, eol)
+	writeFsckFiles(&b, "Orphaned minified text", r.Orphans, eol)
+
+	if len(r.Adopted) != 0 {
+		writeFsckFiles(&b, "Adopted", r.Adopted, eol)
+	}
+
+	if len(r.Deleted) != 0 {
+		writeFsckFiles(&b, "Deleted", r.Deleted, eol)
+	}
+
+	if len(r.Skipped) != 0 {
+		writeFsckFiles(&b, "Not deleted (not converted from this project's PDFs)", r.Skipped, eol)
+	}
+
+	if r.Requeued != 0 {
+		fmt.Fprintf(&b, "Entries queued for conversion: %d%s", r.Requeued, eol)
+	}
+
+	return b.String()
+}
+
+// Suffix added to minified text files imported under an existing name
+// (see importMiniFile)
+var reImportSuffix = regexp.MustCompile(`-[0-9]+$`)
+
+// Returns the pdfKey() of the PDF a minified text file was converted from,
+// based upon its name (see convertPDF)
+func miniFilePDFKey(miniFile string) string {
+	name := reImportSuffix.ReplaceAllString(strings.TrimSuffix(filepath.Base(miniFile), ".txt"), "")
+	return filepath.Join(filepath.Base(filepath.Dir(miniFile)), name)
+}
+
+// Returns the location of a PDF, and whether it exists
+func (p *Project) locatePDF(pdf string) (string, bool) {
+	if _, err := os.Stat(pdf); err == nil {
+		return pdf, true
+	}
+	return p.findPDF(pdf)
+}
+
+// Returns why a minified text file, listed by an entry as converted from
+// `pdf`, is stale, or an empty string if it is not (see staleReason)
+func (p *Project) staleFileReason(e *ProjectEntry, miniFile, pdf string) string {
+	if miniFilePDFKey(miniFile) != pdfKey(pdf) {
+		return "does not correspond to " + pdf
+	}
+
+	local, found := p.locatePDF(pdf)
+	if !found {
+		return ""
+	}
+
+	if c := e.conversion(pdf); c != nil {
+		return c.changed(local)
+	}
+
+	pdfInfo, err := os.Stat(local)
+	if err != nil {
+		return ""
+	}
+
+	if info, err := os.Stat(miniFile); err == nil && info.ModTime().Before(pdfInfo.ModTime()) {
+		return "older than " + local
+	}
+
+	return ""
+}
+
+/*
+ * Returns the first of an entry's minified text files that is stale, and the
+ * reason why, or empty strings if none are. Files are stale if they do not
+ * correspond to the entry's PDFs (e.g., after its PDFs were changed), or if
+ * the PDFs changed since they were converted. For entries converted before
+ * this was recorded (see ConversionInfo), files older than their PDFs are
+ * considered stale. Incomplete and unconverted entries are never stale.
+ *
+ * If the numbers of files and PDFs differ, no file is returned, as the
+ * files may nonetheless be up to date.
+ */
+func (p *Project) staleReason(e *ProjectEntry) (string, string) {
+	if len(e.MiniFiles) == 0 || e.Record.IsIncomplete() {
+		return "", ""
+	}
+
+	if len(e.MiniFiles) != len(e.Record.PDFs) {
+		return "", fmt.Sprintf("%d minified text files for %d PDFs",
+			len(e.MiniFiles), len(e.Record.PDFs))
+	}
+
+	for i, miniFile := range e.MiniFiles {
+		if reason := p.staleFileReason(e, miniFile, e.Record.PDFs[i]); len(reason) != 0 {
+			return miniFile, reason
+		}
+	}
+
+	return "", ""
+}
+
+/*
+ * Delete those of an entry's minified text files that are stale, being
+ * converted from none of its PDFs, or from a PDF that has since changed.
+ * Files that are up to date are kept, for convertPDF() to re-use.
+ */
+func (p *Project) deleteStaleFiles(e *ProjectEntry, report *FsckReport) {
+	for _, miniFile := range e.MiniFiles {
+		stale := true
+		for _, pdf := range e.Record.PDFs {
+			if miniFilePDFKey(miniFile) == pdfKey(pdf) {
+				stale = len(p.staleFileReason(e, miniFile, pdf)) != 0
+				break
+			}
+		}
+
+		if !stale {
+			Debugf("Keeping up-to-date minified text: %s\n", miniFile)
+			continue
+		}
+
+		Verbosef("Deleting stale minified text: %s\n", miniFile)
+		if err := os.Remove(miniFile); err == nil {
+			report.Deleted = append(report.Deleted, miniFile)
+		}
+	}
+}
+
+// Returns the minified text files within the data directory
+func (p *Project) dataDirFiles() ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(p.DataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0 {
+			if strings.HasSuffix(path, ".txt") {
+				files = append(files, path)
+			}
+		}
+		return nil
+	})
+
+	sort.Strings(files)
+	return files, err
+}
+
+/*
+ * Check the project's entries against the contents of its data directory,
+ * optionally repairing the problems found:
+ *	- Dangling minified text files are removed from their entries, which are
+ *	  converted by the next reid-convert run.
+ *	- Stale minified text files are deleted and removed from their entries,
+ *	  so that the entries are converted again. The entries' other minified
+ *	  text files are kept, for reid-convert to re-use.
+ *	- Orphans are deleted or adopted, per `config.Orphans`.
+ *
+ * Small minified text files and missing PDFs are only reported. (See the
+ * reid-convert --ocr flag, and reid-project roots, respectively.)
+ *
+ * Orphans are determined relative to this project alone. If its data
+ * directory is shared with another project, that project's files appear to
+ * be orphans. Therefore, only orphans converted from one of this project's
+ * PDFs are deleted, unless `config.Force` is set.
+ *
+ * The project is not saved.
+ */
+func (p *Project) Fsck(config FsckConfig) (FsckReport, error) {
+	var report FsckReport
+
+	switch config.Orphans {
+	case "", OrphansKeep, OrphansDelete, OrphansAdopt:
+	default:
+		return report, fmt.Errorf("Invalid orphan action: %s", config.Orphans)
+	}
+
+	listed := make(map[string]bool)
+
+	for i := range p.Entries {
+		e := &p.Entries[i]
+		requeue := false
+
+		issue := func(file, detail string) FsckIssue {
+			return FsckIssue{Hash: e.Hash, Record: e.Record, File: file, Detail: detail}
+		}
+
+		for _, pdf := range e.Record.PDFs {
+			if e.Record.isRemoved() {
+				break // No longer converted or searched
+			}
+			if _, found := p.locatePDF(pdf); !found {
+				report.MissingPDFs = append(report.MissingPDFs, issue(pdf, ""))
+			}
+		}
+
+		for _, miniFile := range e.MiniFiles {
+			listed[miniFile] = true
+
+			info, err := os.Stat(miniFile)
+			if err != nil {
+				report.Dangling = append(report.Dangling, issue(miniFile, ""))
+				requeue = true
+			} else if info.Size() <= shortMiniTextThreshold {
+				report.Small = append(report.Small,
+					issue(miniFile, fmt.Sprintf("%d bytes", info.Size())))
+			}
+		}
+
+		if !requeue {
+			if file, reason := p.staleReason(e); len(reason) != 0 {
+				report.Stale = append(report.Stale, issue(file, reason))
+				requeue = true
+
+				// Otherwise, convertPDF() would simply re-use these
+				if config.Repair {
+					p.deleteStaleFiles(e, &report)
+				}
+			}
+		}
+
+		if requeue && config.Repair {
+			Verbosef("Queuing entry for conversion: %s\n", e.Record.String())
+			e.clearConversion()
+			report.Requeued++
+		}
+	}
+
+	files, err := p.dataDirFiles()
+	if err != nil {
+		return report, err
+	}
+
+	var orphans []string
+	for _, f := range files {
+		if !listed[f] {
+			orphans = append(orphans, f)
+		}
+	}
+	report.Orphans = orphans
+
+	if !config.Repair {
+		return report, nil
+	}
+
+	if config.Orphans == OrphansAdopt {
+		orphans = p.adoptOrphans(orphans, &report)
+	}
+
+	if config.Orphans == OrphansDelete {
+		pdfKeys := make(map[string]bool)
+		for i := range p.Entries {
+			for _, pdf := range p.Entries[i].Record.PDFs {
+				pdfKeys[pdfKey(pdf)] = true
+			}
+		}
+
+		for _, f := range orphans {
+			if !pdfKeys[miniFilePDFKey(f)] && !config.Force {
+				Verbosef("Not deleting orphan unrelated to the project's PDFs: %s\n", f)
+				report.Skipped = append(report.Skipped, f)
+				continue
+			}
+
+			Verbosef("Deleting orphaned minified text: %s\n", f)
+			if err := os.Remove(f); err != nil {
+				return report, err
+			}
+			report.Deleted = append(report.Deleted, f)
+		}
+	}
+
+	return report, nil
+}
+
+/*
+ * Assign orphaned minified text files to unconverted entries, when a file
+ * exists for each of an entry's PDFs, and these are not older than the PDFs.
+ * Returns the orphans that were not adopted.
+ */
+func (p *Project) adoptOrphans(orphans []string, report *FsckReport) []string {
+	byKey := make(map[string]string, len(orphans))
+	for _, f := range orphans {
+		byKey[miniFilePDFKey(f)] = f
+	}
+
+	adopted := make(map[string]bool)
+	for i := range p.Entries {
+		e := &p.Entries[i]
+		if len(e.MiniFiles) != 0 || len(e.Record.PDFs) == 0 || e.Record.IsIncomplete() {
+			continue
+		}
+
+		var miniFiles []string
+		for _, pdf := range e.Record.PDFs {
+			if f, found := byKey[pdfKey(pdf)]; found && !adopted[f] {
+				miniFiles = append(miniFiles, f)
+			}
+		}
+
+		if len(miniFiles) != len(e.Record.PDFs) {
+			continue
+		}
+
+		e.MiniFiles = miniFiles
+		if _, reason := p.staleReason(e); len(reason) != 0 {
+			e.clearConversion()
+			continue
+		}
+
+		for _, f := range miniFiles {
+			Verbosef("Adopting %s for: %s\n", f, e.Record.String())
+			adopted[f] = true
+			report.Adopted = append(report.Adopted, f)
+		}
+	}
+
+	var remaining []string
+	for _, f := range orphans {
+		if !adopted[f] {
+			remaining = append(remaining, f)
+		}
+	}
+
+	return remaining
+}
@@ -0,0 +1,437 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Tests of project health checks and repair
+ */
+
+package reid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Minified text long enough not to be reported as suspiciously small
+var fsckText = strings.Repeat("minified text ", 100)
+
+// Create a file, and any directories leading up to it, within `dir`
+func writeTestFile(t *testing.T, dir, name, contents string) string {
+	filename := filepath.Join(dir, filepath.FromSlash(name))
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0770); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(contents), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+// Create a project in `dir`, with an entry for each of the named PDFs, which
+// are created in dir/PDF
+func newFsckProject(t *testing.T, dir string, pdfs ...string) *Project {
+	var records []Record
+
+	for i, pdf := range pdfs {
+		records = append(records, Record{
+			Title:       "Title " + pdf,
+			Authors:     []string{"Author"},
+			Publication: "Publication",
+			Year:        2000 + i,
+			PDFs:        []string{writeTestFile(t, dir, "PDF/"+pdf, "%PDF "+pdf)},
+		})
+	}
+
+	p, err := NewProject(filepath.Join(dir, "data"), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// Returns paths relative to `dir`, for comparison against expected results
+func relPaths(t *testing.T, dir string, paths []string) []string {
+	var rel []string
+	for _, path := range paths {
+		r, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	return rel
+}
+
+var fsckOrphanTests = []struct {
+	name    string
+	config  FsckConfig
+	orphans []string // Files reported as orphans
+	adopted []string // Files adopted by entries
+	deleted []string // Files deleted
+	skipped []string // Orphans that were not deleted
+}{
+	{
+		name:    "report",
+		config:  FsckConfig{Orphans: OrphansDelete},
+		orphans: []string{"data/2/b.pdf.txt", "data/9/other.pdf.txt"},
+	},
+	{
+		name:    "keep",
+		config:  FsckConfig{Repair: true, Orphans: OrphansKeep},
+		orphans: []string{"data/2/b.pdf.txt", "data/9/other.pdf.txt"},
+	},
+	{
+		name:    "adopt",
+		config:  FsckConfig{Repair: true, Orphans: OrphansAdopt},
+		orphans: []string{"data/2/b.pdf.txt", "data/9/other.pdf.txt"},
+		adopted: []string{"data/2/b.pdf.txt"},
+	},
+	{
+		name:    "delete",
+		config:  FsckConfig{Repair: true, Orphans: OrphansDelete},
+		orphans: []string{"data/2/b.pdf.txt", "data/9/other.pdf.txt"},
+		deleted: []string{"data/2/b.pdf.txt"},
+		skipped: []string{"data/9/other.pdf.txt"},
+	},
+	{
+		name:    "force delete",
+		config:  FsckConfig{Repair: true, Orphans: OrphansDelete, Force: true},
+		orphans: []string{"data/2/b.pdf.txt", "data/9/other.pdf.txt"},
+		deleted: []string{"data/2/b.pdf.txt", "data/9/other.pdf.txt"},
+	},
+}
+
+func TestFsckOrphans(t *testing.T) {
+	for _, test := range fsckOrphanTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			// a.pdf is converted, b.pdf has an orphan it may adopt, and
+			// other.pdf.txt belongs to a project sharing the data directory
+			p := newFsckProject(t, dir, "1/a.pdf", "2/b.pdf")
+			p.Entries[0].MiniFiles = []string{writeTestFile(t, dir, "data/1/a.pdf.txt", fsckText)}
+			writeTestFile(t, dir, "data/2/b.pdf.txt", fsckText)
+			writeTestFile(t, dir, "data/9/other.pdf.txt", fsckText)
+
+			report, err := p.Fsck(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results := []struct {
+				what   string
+				got    []string
+				expect []string
+			}{
+				{"Orphans", report.Orphans, test.orphans},
+				{"Adopted", report.Adopted, test.adopted},
+				{"Deleted", report.Deleted, test.deleted},
+				{"Skipped", report.Skipped, test.skipped},
+			}
+
+			for _, r := range results {
+				if got := relPaths(t, dir, r.got); !reflect.DeepEqual(got, r.expect) {
+					t.Errorf("%s: got %v, expected %v", r.what, got, r.expect)
+				}
+			}
+
+			for _, f := range report.Deleted {
+				if _, err := os.Stat(f); err == nil {
+					t.Errorf("Not deleted: %s", f)
+				}
+			}
+
+			for _, f := range report.Skipped {
+				if _, err := os.Stat(f); err != nil {
+					t.Errorf("Skipped file was deleted: %s", f)
+				}
+			}
+
+			adopted := relPaths(t, dir, p.Entries[1].MiniFiles)
+			if !reflect.DeepEqual(adopted, test.adopted) {
+				t.Errorf("MiniFiles of the adopting entry: got %v, expected %v", adopted, test.adopted)
+			}
+		})
+	}
+}
+
+var fsckStaleTests = []struct {
+	name      string
+	pdfs      []string // PDFs of the entry
+	miniFiles []string // Minified text files listed by the entry
+	older     []string // Minified text files older than their PDFs
+	modified  []string // PDFs modified since their conversion was recorded
+	stale     string   // Reported stale file, if any
+	deleted   []string // Files deleted by the repair
+}{
+	{
+		name:      "up to date",
+		pdfs:      []string{"1/a.pdf", "2/b.pdf"},
+		miniFiles: []string{"data/1/a.pdf.txt", "data/2/b.pdf.txt"},
+	},
+	{
+		name:      "older than PDF",
+		pdfs:      []string{"1/a.pdf", "2/b.pdf"},
+		miniFiles: []string{"data/1/a.pdf.txt", "data/2/b.pdf.txt"},
+		older:     []string{"data/2/b.pdf.txt"},
+		stale:     "data/2/b.pdf.txt",
+		deleted:   []string{"data/2/b.pdf.txt"},
+	},
+	{
+		name:      "PDF modified",
+		pdfs:      []string{"1/a.pdf", "2/b.pdf"},
+		miniFiles: []string{"data/1/a.pdf.txt", "data/2/b.pdf.txt"},
+		modified:  []string{"1/a.pdf"},
+		stale:     "data/1/a.pdf.txt",
+		deleted:   []string{"data/1/a.pdf.txt"},
+	},
+	{
+		name:      "different PDF",
+		pdfs:      []string{"1/a.pdf"},
+		miniFiles: []string{"data/3/c.pdf.txt"},
+		stale:     "data/3/c.pdf.txt",
+		deleted:   []string{"data/3/c.pdf.txt"},
+	},
+	{
+		name:      "PDF added",
+		pdfs:      []string{"1/a.pdf", "2/b.pdf"},
+		miniFiles: []string{"data/1/a.pdf.txt"},
+		stale:     "",
+	},
+	{
+		name:      "PDF removed",
+		pdfs:      []string{"1/a.pdf"},
+		miniFiles: []string{"data/1/a.pdf.txt", "data/2/b.pdf.txt"},
+		stale:     "",
+		deleted:   []string{"data/2/b.pdf.txt"},
+	},
+	{
+		name:      "PDF added, older than PDF",
+		pdfs:      []string{"1/a.pdf", "2/b.pdf"},
+		miniFiles: []string{"data/1/a.pdf.txt"},
+		older:     []string{"data/1/a.pdf.txt"},
+		stale:     "",
+		deleted:   []string{"data/1/a.pdf.txt"},
+	},
+}
+
+func TestFsckStale(t *testing.T) {
+	for _, test := range fsckStaleTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			p := newFsckProject(t, dir, test.pdfs[0])
+			e := &p.Entries[0]
+			for _, pdf := range test.pdfs[1:] {
+				e.Record.PDFs = append(e.Record.PDFs, writeTestFile(t, dir, "PDF/"+pdf, "%PDF "+pdf))
+			}
+
+			for _, f := range test.miniFiles {
+				e.MiniFiles = append(e.MiniFiles, writeTestFile(t, dir, f, fsckText))
+			}
+
+			earlier := time.Now().Add(-time.Hour)
+			for _, f := range test.older {
+				if err = os.Chtimes(filepath.Join(dir, f), earlier, earlier); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, pdf := range test.modified {
+				filename := filepath.Join(dir, "PDF", pdf)
+				e.setConversion(newConversionInfo(filename, ConversionUnknown, len(fsckText)))
+				writeTestFile(t, dir, "PDF/"+pdf, "%PDF modified "+pdf)
+			}
+
+			report, err := p.Fsck(FsckConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectStale := len(e.MiniFiles) != len(e.Record.PDFs) || len(test.stale) != 0
+			if len(report.Stale) != 0 != expectStale {
+				t.Fatalf("Got %d stale entries, expected stale=%v", len(report.Stale), expectStale)
+			} else if !expectStale {
+				return
+			}
+
+			// Files may be up to date when the numbers of files and PDFs differ
+			if len(test.stale) == 0 {
+				if len(report.Stale[0].File) != 0 {
+					t.Errorf("Stale file: got %s, expected none", report.Stale[0].File)
+				}
+			} else if file := relPaths(t, dir, []string{report.Stale[0].File})[0]; file != test.stale {
+				t.Errorf("Stale file: got %s, expected %s", file, test.stale)
+			}
+
+			if report, err = p.Fsck(FsckConfig{Repair: true}); err != nil {
+				t.Fatal(err)
+			}
+
+			if deleted := relPaths(t, dir, report.Deleted); !reflect.DeepEqual(deleted, test.deleted) {
+				t.Errorf("Deleted: got %v, expected %v", deleted, test.deleted)
+			}
+
+			if report.Requeued != 1 || len(e.MiniFiles) != 0 || len(e.Conversions) != 0 {
+				t.Error("Entry was not queued for conversion")
+			}
+
+			// Files that were not deleted must remain on disk
+			for _, f := range test.miniFiles {
+				deleted := false
+				for _, d := range test.deleted {
+					deleted = deleted || d == f
+				}
+
+				if _, err := os.Stat(filepath.Join(dir, f)); (err == nil) == deleted {
+					t.Errorf("%s: deleted=%v, expected %v", f, err != nil, deleted)
+				}
+			}
+		})
+	}
+}
+
+var adoptOrphanTests = []struct {
+	name       string
+	pdfs       [][]string // PDFs of each entry
+	incomplete bool       // Whether the first entry is incomplete
+	orphans    []string   // Orphaned minified text files
+	older      []string   // Orphans older than their PDFs
+	adopted    [][]string // Expected minified text files of each entry
+	remaining  []string   // Orphans expected to remain
+}{
+	{
+		name:    "single PDF",
+		pdfs:    [][]string{{"1/a.pdf"}},
+		orphans: []string{"data/1/a.pdf.txt"},
+		adopted: [][]string{{"data/1/a.pdf.txt"}},
+	},
+	{
+		name:    "imported under another name",
+		pdfs:    [][]string{{"1/a.pdf"}},
+		orphans: []string{"data/1/a.pdf-2.txt"},
+		adopted: [][]string{{"data/1/a.pdf-2.txt"}},
+	},
+	{
+		name:    "several PDFs",
+		pdfs:    [][]string{{"1/a.pdf", "1/b.pdf"}},
+		orphans: []string{"data/1/b.pdf.txt", "data/1/a.pdf.txt"},
+		adopted: [][]string{{"data/1/a.pdf.txt", "data/1/b.pdf.txt"}},
+	},
+	{
+		name:      "missing a PDF's file",
+		pdfs:      [][]string{{"1/a.pdf", "1/b.pdf"}},
+		orphans:   []string{"data/1/a.pdf.txt"},
+		adopted:   [][]string{nil},
+		remaining: []string{"data/1/a.pdf.txt"},
+	},
+	{
+		name:      "older than PDF",
+		pdfs:      [][]string{{"1/a.pdf"}},
+		orphans:   []string{"data/1/a.pdf.txt"},
+		older:     []string{"data/1/a.pdf.txt"},
+		adopted:   [][]string{nil},
+		remaining: []string{"data/1/a.pdf.txt"},
+	},
+	{
+		name:       "incomplete entry",
+		pdfs:       [][]string{{"1/a.pdf"}},
+		incomplete: true,
+		orphans:    []string{"data/1/a.pdf.txt"},
+		adopted:    [][]string{nil},
+		remaining:  []string{"data/1/a.pdf.txt"},
+	},
+	{
+		name:    "adopted once",
+		pdfs:    [][]string{{"1/a.pdf"}, {"1/a.pdf"}},
+		orphans: []string{"data/1/a.pdf.txt"},
+		adopted: [][]string{{"data/1/a.pdf.txt"}, nil},
+	},
+	{
+		name:      "unrelated",
+		pdfs:      [][]string{{"1/a.pdf"}},
+		orphans:   []string{"data/2/a.pdf.txt"},
+		adopted:   [][]string{nil},
+		remaining: []string{"data/2/a.pdf.txt"},
+	},
+}
+
+func TestAdoptOrphans(t *testing.T) {
+	for _, test := range adoptOrphanTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var records []Record
+			for i, pdfs := range test.pdfs {
+				r := testRecord("Title", 0, "")
+				r.Year += i
+				for _, pdf := range pdfs {
+					r.PDFs = append(r.PDFs, writeTestFile(t, dir, "PDF/"+pdf, "%PDF "+pdf))
+				}
+				records = append(records, r)
+			}
+
+			if test.incomplete {
+				records[0].Publication = ""
+				records[0].Status = records[0].incompleteStatus()
+			}
+
+			p, err := NewProject(filepath.Join(dir, "data"), records)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var orphans []string
+			for _, f := range test.orphans {
+				orphans = append(orphans, writeTestFile(t, dir, f, fsckText))
+			}
+
+			earlier := time.Now().Add(-time.Hour)
+			for _, f := range test.older {
+				if err = os.Chtimes(filepath.Join(dir, f), earlier, earlier); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var report FsckReport
+			remaining := relPaths(t, dir, p.adoptOrphans(orphans, &report))
+			if !reflect.DeepEqual(remaining, test.remaining) {
+				t.Errorf("Remaining: got %v, expected %v", remaining, test.remaining)
+			}
+
+			var adopted []string
+			for i := range p.Entries {
+				got := relPaths(t, dir, p.Entries[i].MiniFiles)
+				if !reflect.DeepEqual(got, test.adopted[i]) {
+					t.Errorf("Entry %d: got %v, expected %v", i, got, test.adopted[i])
+				}
+				adopted = append(adopted, got...)
+			}
+
+			if got := relPaths(t, dir, report.Adopted); !reflect.DeepEqual(got, adopted) {
+				t.Errorf("Reported adoptions %v, expected %v", got, adopted)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Record identity
+ *
+ * Project entries were originally identified by their metadata hash (see
+ * Record.Hash). This changes whenever a typo in a record is corrected, and
+ * unrelated records may share one. Entries are now identified by their
+ * library record number or DOI, when available, and by their metadata hash
+ * otherwise. The scheme used to identify each entry is stored with it.
+ */
+
+package reid
+
+import (
+	"crypto/md5"
+	"strconv"
+	"strings"
+)
+
+// Identity schemes, in order of preference
+const (
+	IDSchemeRecNumber = "rec-number" // Library record number (see Record.RecNumber)
+	IDSchemeDOI       = "doi"        // Digital Object Identifier
+	IDSchemeHash      = "hash"       // Metadata hash (see Record.Hash)
+)
+
+func identityHash(scheme, value string) RecordHash {
+	return RecordHash(md5.Sum([]byte(scheme + "|" + value)))
+}
+
+/*
+ * Returns the preferred identifier of the record, and the scheme used to
+ * derive it. Identifiers are the same size as metadata hashes, so that either
+ * may be used to select project entries.
+ */
+func (r *Record) Identity() (RecordHash, string) {
+	if r.RecNumber > 0 {
+		return identityHash(IDSchemeRecNumber, strconv.Itoa(r.RecNumber)), IDSchemeRecNumber
+	}
+
+	if doi := strings.ToLower(strings.TrimSpace(r.DOI)); len(doi) != 0 {
+		return identityHash(IDSchemeDOI, doi), IDSchemeDOI
+	}
+
+	return r.Hash(), IDSchemeHash
+}
+
+// Same as Identity(), but returns a string representation of the identifier
+func (r *Record) IdentityString() string {
+	id, _ := r.Identity()
+	return id.String()
+}
+
+/*
+ * Returns the entry's preferred identifier, and the scheme used to derive it.
+ * Entries merged from another project (see Project.Merge) are not identified
+ * by record number, as it is specific to the other project's library, and
+ * could collide with that of an unrelated record in this project's library.
+ */
+func (e *ProjectEntry) identity() (RecordHash, string) {
+	if len(e.MergedFrom) != 0 && e.Record.RecNumber > 0 {
+		r := e.Record
+		r.RecNumber = 0
+		return r.Identity()
+	}
+	return e.Record.Identity()
+}
+
+/*
+ * Assign the entry its preferred identifier. If this is used by another entry
+ * (e.g., two book chapters sharing a DOI), its metadata hash is used instead.
+ *
+ * If the entry's identifier changes, its previous identifier is retained in
+ * PrevHashes, so that it may still be used to select the entry.
+ */
+func (e *ProjectEntry) setIdentity(inUse func(id string) bool) {
+	id, scheme := e.identity()
+	if scheme != IDSchemeHash && inUse(id.String()) {
+		Verbosef("Identifier (%s) already in use. Using metadata hash for: %s\n",
+			scheme, e.Record.String())
+		id, scheme = e.Record.Hash(), IDSchemeHash
+	}
+
+	if s := id.String(); s != e.Hash {
+		if len(e.Hash) != 0 {
+			e.PrevHashes = append(e.PrevHashes, e.Hash)
+		}
+		e.Hash = s
+
+		// Don't list the current identifier as a previous one
+		for i, prev := range e.PrevHashes {
+			if prev == s {
+				e.PrevHashes = append(e.PrevHashes[:i], e.PrevHashes[i+1:]...)
+				break
+			}
+		}
+	}
+
+	e.IDScheme = scheme
+}
+
+// Assign identifiers to all entries, in order
+func (p *Project) assignIdentities() {
+	used := make(map[string]bool, len(p.Entries))
+	for i := range p.Entries {
+		p.Entries[i].setIdentity(func(id string) bool { return used[id] })
+		used[p.Entries[i].Hash] = true
+	}
+}
+
+// Reassign an entry's identifier after its record has been modified
+func (p *Project) updateIdentity(e *ProjectEntry) {
+	e.setIdentity(func(id string) bool {
+		for i := range p.Entries {
+			if &p.Entries[i] != e && p.Entries[i].Hash == id {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Tests of record identity assignment
+ */
+
+package reid
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testRecord(title string, recNumber int, doi string) Record {
+	return Record{
+		Title:       title,
+		Authors:     []string{"Author"},
+		Publication: "Publication",
+		Year:        2000,
+		RecNumber:   recNumber,
+		DOI:         doi,
+	}
+}
+
+func hashOf(r Record) string {
+	return r.HashString()
+}
+
+var recordIdentityTests = []struct {
+	name   string
+	record Record
+	scheme string
+	value  string // Value the identifier is derived from, for non-hash schemes
+}{
+	{"rec number", testRecord("A", 7, ""), IDSchemeRecNumber, "7"},
+	{"rec number preferred", testRecord("A", 7, "10.1000/a"), IDSchemeRecNumber, "7"},
+	{"DOI", testRecord("A", 0, "10.1000/a"), IDSchemeDOI, "10.1000/a"},
+	{"DOI case and space", testRecord("A", 0, " 10.1000/A "), IDSchemeDOI, "10.1000/a"},
+	{"hash", testRecord("A", 0, ""), IDSchemeHash, ""},
+	{"hash when DOI blank", testRecord("A", 0, "  "), IDSchemeHash, ""},
+}
+
+func TestRecordIdentity(t *testing.T) {
+	for _, test := range recordIdentityTests {
+		t.Run(test.name, func(t *testing.T) {
+			expect := test.record.Hash()
+			if test.scheme != IDSchemeHash {
+				expect = identityHash(test.scheme, test.value)
+			}
+
+			id, scheme := test.record.Identity()
+			if id != expect || scheme != test.scheme {
+				t.Errorf("Got %s (%s), expected %s (%s)", id, scheme, expect, test.scheme)
+			}
+		})
+	}
+}
+
+// Entries merged from another project are not identified by record number
+func TestMergedEntryIdentity(t *testing.T) {
+	e := ProjectEntry{Record: testRecord("A", 7, "10.1000/a"), MergedFrom: "other.json"}
+	if _, scheme := e.identity(); scheme != IDSchemeDOI {
+		t.Errorf("Got scheme %s, expected %s", scheme, IDSchemeDOI)
+	}
+
+	e.Record.DOI = ""
+	if id, scheme := e.identity(); scheme != IDSchemeHash || id != e.Record.Hash() {
+		t.Errorf("Got %s (%s), expected the metadata hash", id, scheme)
+	}
+
+	if e.Record.RecNumber != 7 {
+		t.Error("Record number was modified")
+	}
+}
+
+var setIdentityTests = []struct {
+	name       string
+	record     Record
+	hash       string   // Entry's identifier prior to the update
+	prevHashes []string // Entry's previous identifiers prior to the update
+	inUse      []string // Identifiers used by other entries
+	scheme     string
+	expectPrev []string // Expected previous identifiers, with "old" denoting `hash`
+}{
+	{
+		name:   "new entry",
+		record: testRecord("A", 7, ""),
+		scheme: IDSchemeRecNumber,
+	},
+	{
+		name:       "migrated from hash",
+		record:     testRecord("A", 7, ""),
+		hash:       "0123456789abcdef0123456789abcdef",
+		scheme:     IDSchemeRecNumber,
+		expectPrev: []string{"old"},
+	},
+	{
+		name:       "previous identifiers retained",
+		record:     testRecord("A", 0, "10.1000/a"),
+		hash:       "0123456789abcdef0123456789abcdef",
+		prevHashes: []string{"fedcba9876543210fedcba9876543210"},
+		scheme:     IDSchemeDOI,
+		expectPrev: []string{"fedcba9876543210fedcba9876543210", "old"},
+	},
+	{
+		name:   "DOI in use",
+		record: testRecord("A", 0, "10.1000/a"),
+		inUse:  []string{identityHash(IDSchemeDOI, "10.1000/a").String()},
+		scheme: IDSchemeHash,
+	},
+	{
+		name:   "rec number in use",
+		record: testRecord("A", 7, "10.1000/a"),
+		inUse:  []string{identityHash(IDSchemeRecNumber, "7").String()},
+		scheme: IDSchemeHash,
+	},
+	{
+		name:   "metadata hash in use",
+		record: testRecord("A", 0, ""),
+		inUse:  []string{hashOf(testRecord("A", 0, ""))},
+		scheme: IDSchemeHash,
+	},
+	{
+		name:       "unchanged",
+		record:     testRecord("A", 7, ""),
+		hash:       identityHash(IDSchemeRecNumber, "7").String(),
+		prevHashes: []string{"0123456789abcdef0123456789abcdef"},
+		scheme:     IDSchemeRecNumber,
+		expectPrev: []string{"0123456789abcdef0123456789abcdef"},
+	},
+	{
+		name:       "reverted to previous identifier",
+		record:     testRecord("A", 0, ""),
+		hash:       identityHash(IDSchemeDOI, "10.1000/a").String(),
+		prevHashes: []string{hashOf(testRecord("A", 0, ""))},
+		scheme:     IDSchemeHash,
+		expectPrev: []string{"old"},
+	},
+}
+
+func TestSetIdentity(t *testing.T) {
+	for _, test := range setIdentityTests {
+		t.Run(test.name, func(t *testing.T) {
+			e := ProjectEntry{Record: test.record, Hash: test.hash}
+			e.PrevHashes = append(e.PrevHashes, test.prevHashes...)
+
+			e.setIdentity(func(id string) bool {
+				for _, used := range test.inUse {
+					if id == used {
+						return true
+					}
+				}
+				return false
+			})
+
+			expect, _ := test.record.Identity()
+			if test.scheme == IDSchemeHash {
+				expect = test.record.Hash()
+			}
+
+			if e.Hash != expect.String() || e.IDScheme != test.scheme {
+				t.Errorf("Got %s (%s), expected %s (%s)", e.Hash, e.IDScheme, expect, test.scheme)
+			}
+
+			var expectPrev []string
+			for _, prev := range test.expectPrev {
+				if prev == "old" {
+					prev = test.hash
+				}
+				expectPrev = append(expectPrev, prev)
+			}
+
+			if len(e.PrevHashes) != 0 || len(expectPrev) != 0 {
+				if !reflect.DeepEqual(e.PrevHashes, expectPrev) {
+					t.Errorf("PrevHashes: got %v, expected %v", e.PrevHashes, expectPrev)
+				}
+			}
+		})
+	}
+}
+
+// The first of the entries sharing an identifier keeps it
+func TestAssignIdentities(t *testing.T) {
+	p := Project{Entries: []ProjectEntry{
+		{Record: testRecord("Chapter 1", 0, "10.1000/book")},
+		{Record: testRecord("Chapter 2", 0, "10.1000/book")},
+		{Record: testRecord("Other", 3, "")},
+	}}
+
+	p.assignIdentities()
+
+	schemes := []string{IDSchemeDOI, IDSchemeHash, IDSchemeRecNumber}
+	for i, e := range p.Entries {
+		if e.IDScheme != schemes[i] {
+			t.Errorf("Entry %d: got scheme %s, expected %s", i, e.IDScheme, schemes[i])
+		}
+	}
+
+	if p.Entries[1].Hash != p.Entries[1].Record.HashString() {
+		t.Error("Entry sharing a DOI was not identified by its metadata hash")
+	}
+}
+
+// Entries are reassigned identifiers after being edited, without taking
+// those of other entries
+func TestUpdateIdentity(t *testing.T) {
+	p := Project{Entries: []ProjectEntry{
+		{Record: testRecord("A", 0, "10.1000/a")},
+		{Record: testRecord("B", 0, "")},
+	}}
+	p.assignIdentities()
+
+	e := &p.Entries[1]
+	old := e.Hash
+
+	e.Record.DOI = "10.1000/b"
+	p.updateIdentity(e)
+	if e.IDScheme != IDSchemeDOI || !reflect.DeepEqual(e.PrevHashes, []string{old}) {
+		t.Errorf("Got %s (%s) with previous %v", e.Hash, e.IDScheme, e.PrevHashes)
+	}
+
+	// Now shares the DOI of the first entry
+	e.Record.DOI = "10.1000/a"
+	p.updateIdentity(e)
+	if e.IDScheme != IDSchemeHash || e.Hash != old || e.Hash == p.Entries[0].Hash {
+		t.Errorf("Got %s (%s), expected the metadata hash %s", e.Hash, e.IDScheme, old)
+	}
+
+	// Re-assigning an entry's own identifier is not a collision
+	p.updateIdentity(&p.Entries[0])
+	if p.Entries[0].IDScheme != IDSchemeDOI {
+		t.Errorf("Entry collided with itself (%s)", p.Entries[0].IDScheme)
+	}
+}
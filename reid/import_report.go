@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Import report, describing records that were not loaded from a library
+ */
+
+package reid
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reasons a record was not loaded
+const (
+	IssueMissingTitle       = "missing title"
+	IssueMissingPublication = "missing publication"
+	IssueMissingYear        = "missing year"
+	IssueInvalidYear        = "invalid year"
+	IssueMissingAuthors     = "missing authors"
+	IssueMissingPDFs        = "missing PDFs"
+	IssueDuplicate          = "duplicate hash"
+	IssueLanguage           = "language filter"
+)
+
+// Map the field name returned by Record.isComplete() to an issue reason
+func incompleteReason(missing string) string {
+	switch missing {
+	case "Title":
+		return IssueMissingTitle
+	case "Publication":
+		return IssueMissingPublication
+	case "Year":
+		return IssueMissingYear
+	case "Authors":
+		return IssueMissingAuthors
+	case "PDFs":
+		return IssueMissingPDFs
+	default:
+		return "missing " + strings.ToLower(missing)
+	}
+}
+
+// A record that was not loaded, and why
+type ImportIssue struct {
+	RecNumber int    // Library record number, or 0 if not known
+	Title     string // Record title, if known
+	Line      int    // Line number of the start of the record
+	Offset    int64  // Byte offset of the start of the record
+	Reason    string // One of the Issue* constants
+	Detail    string // Additional information (e.g., the invalid value)
+}
+
+func (i ImportIssue) Pretty() string {
+	var s string
+
+	s = fmt.Sprintf("Line %d (offset %d): ", i.Line, i.Offset)
+
+	if i.RecNumber != 0 {
+		s += fmt.Sprintf("Record %d ", i.RecNumber)
+	} else {
+		s += "Record "
+	}
+
+	if len(i.Title) != 0 {
+		s += fmt.Sprintf("\"%s\" ", i.Title)
+	}
+
+	s += "- " + i.Reason
+	if len(i.Detail) != 0 {
+		s += " (" + i.Detail + ")"
+	}
+
+	return s
+}
+
+func ImportIssueCSVHeader(sep, eol string) string {
+	return fmt.Sprintf(
+		"Line%s"+
+			"Offset%s"+
+			"Record Number%s"+
+			"Title%s"+
+			"Reason%s"+
+			"Detail%s",
+		sep, sep, sep, sep, sep, eol)
+}
+
+func (i ImportIssue) CSV(sep, eol string) string {
+	return fmt.Sprintf(
+		`"%d"%s`+ // Line
+			`"%d"%s`+ // Offset
+			`"%d"%s`+ // Record Number
+			`"%s"%s`+ // Title
+			`"%s"%s`+ // Reason
+			`"%s"%s`, // Detail
+		i.Line, sep,
+		i.Offset, sep,
+		i.RecNumber, sep,
+		csvEscape(i.Title), sep,
+		i.Reason, sep,
+		csvEscape(i.Detail), eol)
+}
+
+// Double any quotes, per RFC 4180
+func csvEscape(s string) string {
+	return strings.Replace(s, `"`, `""`, -1)
+}
+
+type ImportReport struct {
+	Filename   string        // Library file the report pertains to
+	Records    int           // Total number of records encountered
+	Loaded     int           // Number of records loaded
+	Incomplete int           // Number of loaded records that are incomplete
+	Issues     []ImportIssue // Records that were not loaded
+}
+
+func (r *ImportReport) add(issue ImportIssue) {
+	Debugf("Not loading record at line %d: %s\n", issue.Line, issue.Reason)
+	r.Issues = append(r.Issues, issue)
+}
+
+// Number of issues, by reason
+func (r *ImportReport) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, issue := range r.Issues {
+		counts[issue.Reason]++
+	}
+	return counts
+}
+
+func (r *ImportReport) Pretty(eol string) string {
+	var s string
+
+	s = fmt.Sprintf("%s: %d records, %d loaded, %d not loaded%s",
+		r.Filename, r.Records, r.Loaded, len(r.Issues), eol)
+
+	if r.Incomplete != 0 {
+		s += fmt.Sprintf("   (%d of the loaded records are incomplete)%s",
+			r.Incomplete, eol)
+	}
+
+	counts := r.Counts()
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	for _, reason := range reasons {
+		s += fmt.Sprintf("   %-20s %d%s", reason+":", counts[reason], eol)
+	}
+
+	if len(r.Issues) != 0 {
+		s += eol
+	}
+
+	for _, issue := range r.Issues {
+		s += issue.Pretty() + eol
+	}
+
+	return s
+}
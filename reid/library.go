@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Functionality shared by the various library (XML, RIS, etc.) loaders
+ */
+package reid
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Caller-provided library loading configuration
+type LoadConfig struct {
+	Languages []string // Filter records (inclusively) based upon language
+	PDFDir    string   // Location of PDFs, for libraries that lack paths to them
+	PathMap   PathMap  // Translations applied to paths in the library
+
+	// Keep records that lack PDFs, a publication, a year, or authors,
+	// rather than dropping them. See Record.Status.
+	KeepIncomplete bool
+}
+
+// Perform case-insensitive language comparisons by lowercasing the filter
+// list in place.
+func lowerLangs(filterLangs []string) {
+	for i := 0; i < len(filterLangs); i++ {
+		filterLangs[i] = strings.ToLower(filterLangs[i])
+	}
+}
+
+func logIncomplete(rec *Record, missing string) {
+	if missing == "Title" {
+		Debug("Not including incomplete record - missing Title\n")
+	} else {
+		Debugf("Not including incomplete record \"%s\" - missing %s\n",
+			rec.Title, missing)
+	}
+}
+
+// Determine whether a loaded record should be kept. Incomplete records are
+// dropped unless `keepIncomplete` is set, in which case those with a title are
+// kept and flagged via their Status.
+//
+// Returns false, along with the name of the first missing field, if the
+// record is to be dropped.
+func acceptRecord(rec *Record, keepIncomplete bool) (bool, string) {
+	complete, missing := rec.isComplete()
+	if complete {
+		return true, ""
+	}
+
+	if keepIncomplete && missing != "Title" {
+		rec.Status = rec.incompleteStatus()
+		Debugf("Keeping incomplete record \"%s\" - %s\n",
+			rec.Title, strings.Join(rec.Status, ", "))
+		return true, ""
+	}
+
+	logIncomplete(rec, missing)
+	return false, missing
+}
+
+// Apply the language filter and duplicate detection to a loaded record,
+// inserting it into `records` if it passes both. Otherwise, the reason the
+// record was not inserted (IssueLanguage or IssueDuplicate) is returned.
+func insertRecord(records *RecordSet, rec *Record, filterLangs []string) string {
+	if !rec.IsWrittenIn(filterLangs) {
+		Debugf("Not including due to Language=%s: %s\n", rec.Language, rec)
+		return IssueLanguage
+	}
+
+	if !records.Insert(rec) {
+		Debug("Not including potential duplicate:", rec)
+		return IssueDuplicate
+	}
+
+	Debug("Loaded record: ", rec)
+	return ""
+}
+
+// Convert a file link (e.g., a file:// URL, an absolute path, or a path
+// relative to `baseDir`) to a local path, translating it with `pathMap`.
+// Returns false if `link` refers to a remote resource (e.g., an http:// URL).
+func localFilePath(link, baseDir string, pathMap PathMap) (string, bool) {
+	link = strings.TrimSpace(link)
+	if len(link) == 0 {
+		return "", false
+	}
+
+	if strings.HasPrefix(strings.ToLower(link), "file:") {
+		u, err := url.Parse(link)
+		if err != nil {
+			Debugf("Failed to parse file URL \"%s\": %s\n", link, err)
+			return "", false
+		}
+
+		// file:relative/path.pdf is parsed as an opaque URL
+		path := u.Path
+		if len(path) == 0 {
+			if path, err = url.PathUnescape(u.Opaque); err != nil {
+				return "", false
+			}
+		}
+		link = path
+	} else if isWindowsPath(link) {
+		// Not a URL, despite what url.Parse() may think
+	} else if u, err := url.Parse(link); err == nil && len(u.Scheme) != 0 {
+		// Any other scheme is something we can't read locally
+		return "", false
+	}
+
+	link = pathMap.Translate(link)
+
+	if !filepath.IsAbs(link) && len(baseDir) != 0 {
+		link = filepath.Join(baseDir, link)
+	}
+
+	return link, true
+}
+
+var reDOI = regexp.MustCompile(`10\.[0-9]{4,9}/\S+`)
+
+// Extract a DOI from a field that may also contain a "doi:" prefix or
+// resolver URL. The field is returned as-is if it does not contain a DOI.
+func normalizeDOI(s string) string {
+	s = strings.TrimSpace(s)
+	if doi := reDOI.FindString(s); len(doi) != 0 {
+		if unescaped, err := url.PathUnescape(doi); err == nil {
+			doi = unescaped
+		}
+		return strings.TrimRight(doi, ".,;")
+	}
+	return s
+}
+
+// Returns true if the provided filename has a .pdf extension
+func isPDF(filename string) bool {
+	return strings.ToLower(filepath.Ext(filename)) == ".pdf"
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Advisory file locking is not implemented on Windows
+ */
+
+package reid
+
+import (
+	"os"
+)
+
+func lockFile(f *os.File, exclusive bool) error {
+	Debugf("Project locking is not supported on Windows: %s\n", f.Name())
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
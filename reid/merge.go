@@ -0,0 +1,342 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Merging of projects created from different libraries
+ */
+
+package reid
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// A record present in both projects, with differing metadata. The metadata
+// of the target project's entry is retained.
+type MergeConflict struct {
+	Hash   string   // Identifier of the entry in the merged project
+	Target Record   // Record in the target project
+	Source Record   // Record in the merged project
+	Fields []string // Names of the fields that differ
+}
+
+// Summary of the changes made by Project.Merge()
+type MergeSummary struct {
+	Added     int // Entries added to the target project
+	Merged    int // Entries present in both projects
+	Copied    int // Minified text files copied (or linked) into the data directory
+	Conflicts []MergeConflict
+}
+
+func (s MergeSummary) Pretty(eol string) string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "Added:     %d%s", s.Added, eol)
+	fmt.Fprintf(&b, "Merged:    %d%s", s.Merged, eol)
+	fmt.Fprintf(&b, "Copied:    %d minified text files%s", s.Copied, eol)
+	fmt.Fprintf(&b, "Conflicts: %d%s", len(s.Conflicts), eol)
+
+	for _, c := range s.Conflicts {
+		fmt.Fprintf(&b, "   %s (%s)%s", c.Hash, strings.Join(c.Fields, ", "), eol)
+		fmt.Fprintf(&b, "      Kept:    %s%s", c.Target.String(), eol)
+		fmt.Fprintf(&b, "      Ignored: %s%s", c.Source.String(), eol)
+	}
+
+	return b.String()
+}
+
+// Returns the names of the metadata fields that differ between two records
+// from different libraries. Record numbers are specific to a library, and
+// are not compared.
+func recordDiff(a, b Record) []string {
+	var fields []string
+
+	a.RecNumber, b.RecNumber = 0, 0
+	if strings.EqualFold(a.DOI, b.DOI) {
+		b.DOI = a.DOI
+	}
+
+	va := reflect.ValueOf(normalizedRecord(a))
+	vb := reflect.ValueOf(normalizedRecord(b))
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, va.Type().Field(i).Name)
+		}
+	}
+
+	return fields
+}
+
+/*
+ * Locates entries of the target project that correspond to those of another
+ * project. Record numbers are specific to a library, so entries are matched
+ * by DOI or by metadata hash, rather than by identifier.
+ */
+type mergeIndex struct {
+	byDOI  map[string]int
+	byHash map[RecordHash]int
+}
+
+func (idx mergeIndex) add(i int, r *Record) {
+	if doi := strings.ToLower(strings.TrimSpace(r.DOI)); len(doi) != 0 {
+		if _, exists := idx.byDOI[doi]; !exists {
+			idx.byDOI[doi] = i
+		}
+	}
+
+	if _, exists := idx.byHash[r.Hash()]; !exists {
+		idx.byHash[r.Hash()] = i
+	}
+}
+
+func (idx mergeIndex) match(r *Record) (int, bool) {
+	if doi := strings.ToLower(strings.TrimSpace(r.DOI)); len(doi) != 0 {
+		if i, found := idx.byDOI[doi]; found {
+			return i, true
+		}
+	}
+
+	i, found := idx.byHash[r.Hash()]
+	return i, found
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func sameContents(a, b string) bool {
+	da, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false
+	}
+
+	db, err := ioutil.ReadFile(b)
+	return err == nil && bytes.Equal(da, db)
+}
+
+/*
+ * Copy (or symbolically link) a minified text file into the project's data
+ * directory, at the location convertPDF() would have written it. If a
+ * different file already exists there, a numeric suffix is added.
+ *
+ * Returns the new path of the file, and whether it was copied.
+ */
+func (p *Project) importMiniFile(miniFile string, link bool) (string, bool, error) {
+	subdir := filepath.Base(filepath.Dir(miniFile))
+	name := strings.TrimSuffix(filepath.Base(miniFile), ".txt")
+
+	if _, err := os.Stat(miniFile); err != nil {
+		return "", false, err
+	}
+
+	for n := 1; ; n++ {
+		dst := filepath.Join(p.DataDir, subdir, name+".txt")
+		if n > 1 {
+			dst = filepath.Join(p.DataDir, subdir, fmt.Sprintf("%s-%d.txt", name, n))
+		}
+
+		if abs, err := filepath.Abs(miniFile); err == nil && abs == dst {
+			return dst, false, nil // Data directories are shared
+		}
+
+		if _, err := os.Lstat(dst); err == nil {
+			if sameContents(miniFile, dst) {
+				return dst, false, nil
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dst), 0770); err != nil {
+			return "", false, err
+		}
+
+		Verbosef("Importing %s to %s\n", miniFile, dst)
+
+		if link {
+			abs, err := filepath.Abs(miniFile)
+			if err != nil {
+				return "", false, err
+			}
+			return dst, true, os.Symlink(abs, dst)
+		}
+
+		return dst, true, copyFile(miniFile, dst)
+	}
+}
+
+// Import an entry's minified text files. If any cannot be imported, none are
+// retained, so that the entry will be converted again.
+func (p *Project) importMiniFiles(e *ProjectEntry, link bool) int {
+	var copied int
+	var miniFiles []string = make([]string, 0, len(e.MiniFiles))
+
+	for _, miniFile := range e.MiniFiles {
+		dst, didCopy, err := p.importMiniFile(miniFile, link)
+		if err != nil {
+			Warnf("Failed to import %s (%s). The entry will need to be converted: %s\n",
+				miniFile, err, e.Record.String())
+			e.clearConversion()
+			return copied
+		}
+
+		if didCopy {
+			copied++
+		}
+		miniFiles = append(miniFiles, dst)
+	}
+
+	e.MiniFiles = miniFiles
+	return copied
+}
+
+/*
+ * Use `dir` as the project's data directory, copying (or, if `link` is set,
+ * symbolically linking) all minified text files into it. The project is not
+ * saved. Returns the number of files copied.
+ */
+func (p *Project) RelocateDataDir(dir string, link bool) (int, error) {
+	var copied int
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = os.MkdirAll(dir, 0770); err != nil {
+		return 0, err
+	}
+
+	p.DataDir = dir
+	for i := range p.Entries {
+		copied += p.importMiniFiles(&p.Entries[i], link)
+	}
+
+	return copied, nil
+}
+
+/*
+ * Merge the entries of another project into this one. Entries describing the
+ * same record (i.e., those with equal DOIs or metadata hashes) are merged,
+ * retaining this project's metadata and reporting any differences as
+ * conflicts. Tags of merged entries are combined, and the other project's
+ * notes are used where this project's entry has none. All other entries are
+ * added, and are identified by DOI or metadata hash, rather than by the
+ * record numbers of the other project's library.
+ *
+ * Minified text files of added entries, and of merged entries that have not
+ * been converted in this project, are copied into this project's data
+ * directory. If `link` is set, symbolic links are created instead.
+ *
+ * Path mappings and aliases of the other project are added to this one,
+ * unless they conflict with this project's. The project is not saved.
+ */
+func (p *Project) Merge(src *Project, link bool) (MergeSummary, error) {
+	var summary MergeSummary
+
+	idx := mergeIndex{
+		byDOI:  make(map[string]int, len(p.Entries)),
+		byHash: make(map[RecordHash]int, len(p.Entries)),
+	}
+
+	used := make(map[string]bool, len(p.Entries)+len(src.Entries))
+	for i := range p.Entries {
+		idx.add(i, &p.Entries[i].Record)
+		used[p.Entries[i].Hash] = true
+	}
+
+	for _, entry := range src.Entries {
+		if i, found := idx.match(&entry.Record); found {
+			e := &p.Entries[i]
+			summary.Merged++
+
+			if fields := recordDiff(e.Record, entry.Record); len(fields) != 0 {
+				summary.Conflicts = append(summary.Conflicts, MergeConflict{
+					Hash: e.Hash, Target: e.Record, Source: entry.Record, Fields: fields,
+				})
+			}
+
+			for _, tag := range entry.Tags {
+				e.addTag(tag)
+			}
+			if len(e.Notes) == 0 {
+				e.Notes = entry.Notes
+			}
+
+			if len(e.MiniFiles) == 0 && len(entry.MiniFiles) != 0 && !e.Record.IsIncomplete() {
+				e.MiniFiles = entry.MiniFiles
+				e.Conversions = entry.Conversions
+				summary.Copied += p.importMiniFiles(e, link)
+			}
+			continue
+		}
+
+		// Former identifiers are specific to the other project, as are
+		// record numbers (see ProjectEntry.identity)
+		entry.PrevHashes = nil
+		if len(entry.MergedFrom) == 0 {
+			entry.MergedFrom = src.filename
+		}
+		if used[entry.Hash] || entry.IDScheme == IDSchemeRecNumber {
+			entry.Hash = ""
+		}
+		entry.setIdentity(func(id string) bool { return used[id] })
+		used[entry.Hash] = true
+
+		summary.Copied += p.importMiniFiles(&entry, link)
+
+		Verbosef("Adding entry: %s\n", entry.Record.String())
+		p.Entries = append(p.Entries, entry)
+		idx.add(len(p.Entries)-1, &entry.Record)
+		summary.Added++
+	}
+
+	for _, mapping := range src.PathMap {
+		if !p.PathMap.has(mapping.From) {
+			p.PathMap = append(p.PathMap, mapping)
+		}
+	}
+
+	for variant, canonical := range src.AuthorAliases {
+		if _, exists := p.AuthorAliases[variant]; !exists {
+			if p.AuthorAliases == nil {
+				p.AuthorAliases = make(AuthorAliases)
+			}
+			p.AuthorAliases[variant] = canonical
+		}
+	}
+
+	for variant, canonical := range src.PublicationAliases {
+		if _, exists := p.PublicationAliases[variant]; !exists {
+			if p.PublicationAliases == nil {
+				p.PublicationAliases = make(PublicationAliases)
+			}
+			p.PublicationAliases[variant] = canonical
+		}
+	}
+
+	_, err := p.scan()
+	return summary, err
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Tests of project merging
+ */
+
+package reid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var importMiniFileTests = []struct {
+	name     string
+	existing map[string]string // Files already in the target data directory
+	source   string            // Location of the imported file, relative to the test directory
+	link     bool
+	expect   string // Expected location, relative to the test directory
+	copied   bool
+}{
+	{
+		name:   "new",
+		source: "src/1/a.pdf.txt",
+		expect: "dst/1/a.pdf.txt",
+		copied: true,
+	},
+	{
+		name:   "link",
+		source: "src/1/a.pdf.txt",
+		link:   true,
+		expect: "dst/1/a.pdf.txt",
+		copied: true,
+	},
+	{
+		name:     "identical file exists",
+		existing: map[string]string{"1/a.pdf.txt": "imported"},
+		source:   "src/1/a.pdf.txt",
+		expect:   "dst/1/a.pdf.txt",
+	},
+	{
+		name:     "different file exists",
+		existing: map[string]string{"1/a.pdf.txt": "other"},
+		source:   "src/1/a.pdf.txt",
+		expect:   "dst/1/a.pdf-2.txt",
+		copied:   true,
+	},
+	{
+		name:     "identical file imported previously",
+		existing: map[string]string{"1/a.pdf.txt": "other", "1/a.pdf-2.txt": "imported"},
+		source:   "src/1/a.pdf.txt",
+		expect:   "dst/1/a.pdf-2.txt",
+	},
+	{
+		name:     "several different files exist",
+		existing: map[string]string{"1/a.pdf.txt": "other", "1/a.pdf-2.txt": "another"},
+		source:   "src/1/a.pdf.txt",
+		expect:   "dst/1/a.pdf-3.txt",
+		copied:   true,
+	},
+	{
+		name:     "shared data directory",
+		existing: map[string]string{"1/a.pdf.txt": "imported"},
+		source:   "dst/1/a.pdf.txt",
+		expect:   "dst/1/a.pdf.txt",
+	},
+}
+
+func TestImportMiniFile(t *testing.T) {
+	for _, test := range importMiniFileTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			p := Project{DataDir: filepath.Join(dir, "dst")}
+			for name, contents := range test.existing {
+				writeTestFile(t, p.DataDir, name, contents)
+			}
+
+			source := filepath.Join(dir, filepath.FromSlash(test.source))
+			if _, err = os.Stat(source); err != nil {
+				writeTestFile(t, dir, test.source, "imported")
+			}
+
+			got, copied, err := p.importMiniFile(source, test.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rel := relPaths(t, dir, []string{got})[0]; rel != test.expect || copied != test.copied {
+				t.Errorf("Got %s (copied=%v), expected %s (copied=%v)", rel, copied, test.expect, test.copied)
+			}
+
+			if data, err := ioutil.ReadFile(got); err != nil || string(data) != "imported" {
+				t.Errorf("Unexpected contents of %s: %s (%v)", got, data, err)
+			}
+
+			if info, err := os.Lstat(got); err != nil {
+				t.Fatal(err)
+			} else if isLink := info.Mode()&os.ModeSymlink != 0; isLink != (test.link && test.copied) {
+				t.Errorf("Symbolic link: got %v, expected %v", isLink, test.link && test.copied)
+			}
+
+			// Renamed files must still be associated with their PDFs (see fsck)
+			if key := miniFilePDFKey(got); key != filepath.Join("1", "a.pdf") {
+				t.Errorf("Imported file corresponds to %s", key)
+			}
+		})
+	}
+}
+
+func TestImportMiniFileMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := Project{DataDir: filepath.Join(dir, "dst")}
+	if _, _, err := p.importMiniFile(filepath.Join(dir, "src/1/a.pdf.txt"), false); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+// Create a project, in a subdirectory of `dir`, with the given records, each
+// of which is converted
+func newMergeProject(t *testing.T, dir, name string, records []Record) *Project {
+	for i := range records {
+		records[i].PDFs = []string{filepath.Join(dir, "PDF", records[i].Title, "paper.pdf")}
+	}
+
+	p, err := NewProject(filepath.Join(dir, name), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range p.Entries {
+		e := &p.Entries[i]
+		miniFile := writeTestFile(t, p.DataDir, e.Record.Title+"/paper.pdf.txt", name+" "+e.Record.Title)
+		e.MiniFiles = []string{miniFile}
+	}
+
+	p.filename = filepath.Join(dir, name+".json")
+	return p
+}
+
+func TestMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// "Shared" is in both libraries, with different record numbers and a
+	// differing year. Record number 1 identifies different records.
+	shared := testRecord("Shared", 1, "10.1000/shared")
+	target := newMergeProject(t, dir, "target", []Record{shared, testRecord("Target", 2, "")})
+	target.Entries[0].Tags = []string{"a"}
+
+	shared.RecNumber, shared.Year = 5, 2001
+	source := newMergeProject(t, dir, "source", []Record{shared, testRecord("Source", 1, "")})
+	source.Entries[0].Tags = []string{"a", "b"}
+	source.Entries[0].Notes = "Source notes"
+	source.Entries[1].PrevHashes = []string{"0123456789abcdef0123456789abcdef"}
+
+	summary, err := target.Merge(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Added != 1 || summary.Merged != 1 || summary.Copied != 1 || len(summary.Conflicts) != 1 {
+		t.Fatalf("Unexpected summary:\n%s", summary.Pretty("\n"))
+	} else if fields := summary.Conflicts[0].Fields; !reflect.DeepEqual(fields, []string{"Year"}) {
+		t.Errorf("Conflicting fields: got %v", fields)
+	}
+
+	// Matched entries keep their metadata and minified text
+	e := &target.Entries[0]
+	if e.Record.Year != 2000 || e.Record.RecNumber != 1 || e.IDScheme != IDSchemeRecNumber {
+		t.Errorf("Matched entry was modified: %s (%s)", e.Record.String(), e.IDScheme)
+	}
+
+	if !reflect.DeepEqual(e.Tags, []string{"a", "b"}) || e.Notes != "Source notes" {
+		t.Errorf("Tags %v and notes \"%s\" were not merged", e.Tags, e.Notes)
+	}
+
+	if rel := relPaths(t, dir, e.MiniFiles); !reflect.DeepEqual(rel, []string{"target/Shared/paper.pdf.txt"}) {
+		t.Errorf("Minified text of the matched entry: %v", rel)
+	}
+
+	// Added entries are not identified by the other library's record number,
+	// which collides with that of "Shared"
+	added := &target.Entries[2]
+	if added.Record.Title != "Source" || added.MergedFrom != source.filename {
+		t.Fatalf("Unexpected entry: %s (merged from %s)", added.Record.String(), added.MergedFrom)
+	}
+
+	if added.IDScheme != IDSchemeHash || added.Hash != added.Record.HashString() || len(added.PrevHashes) != 0 {
+		t.Errorf("Added entry identified by %s (%s), with previous %v",
+			added.Hash, added.IDScheme, added.PrevHashes)
+	}
+
+	if rel := relPaths(t, dir, added.MiniFiles); !reflect.DeepEqual(rel, []string{"target/Source/paper.pdf.txt"}) {
+		t.Errorf("Minified text of the added entry: %v", rel)
+	}
+
+	ids := make(map[string]bool)
+	for _, e := range target.Entries {
+		if ids[e.Hash] {
+			t.Errorf("Identifier used by multiple entries: %s", e.Hash)
+		}
+		ids[e.Hash] = true
+	}
+
+	// Merging again changes nothing
+	if summary, err = target.Merge(source, false); err != nil {
+		t.Fatal(err)
+	} else if summary.Added != 0 || summary.Merged != 2 || summary.Copied != 0 {
+		t.Errorf("Second merge was not a no-op:\n%s", summary.Pretty("\n"))
+	}
+}
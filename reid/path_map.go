@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Translation of library paths (e.g., those recorded by EndNote on a Windows
+ * or macOS machine) to paths on the machine running reid.
+ */
+package reid
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A translation from a path prefix, as it appears in a library, to a local
+// path prefix. For example:
+//
+//	From: C:\Users\me\Documents
+//	To:   /mnt/share/endnote
+type PathMapping struct {
+	From string
+	To   string
+}
+
+type PathMap []PathMapping
+
+// Parse a "FROM=TO" path mapping specification
+func ParsePathMapping(s string) (PathMapping, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return PathMapping{}, fmt.Errorf("Invalid path mapping (expected FROM=TO): %s", s)
+	}
+
+	return PathMapping{From: s[:i], To: s[i+1:]}, nil
+}
+
+func (m PathMapping) String() string {
+	return m.From + "=" + m.To
+}
+
+// Windows drive letter paths, including those taken from file:///C:/... URLs
+var reWinDrive = regexp.MustCompile(`^/?[A-Za-z]:[\\/]`)
+
+// Returns true if `path` appears to be a Windows path (drive letter or UNC)
+func isWindowsPath(path string) bool {
+	return reWinDrive.MatchString(path) || strings.HasPrefix(path, `\\`)
+}
+
+// Convert Windows paths to use forward slashes so that they may be compared
+// with (and joined to) local paths. Other paths are returned unmodified.
+func normalizeSeparators(path string) string {
+	if !isWindowsPath(path) {
+		return path
+	}
+
+	path = strings.Replace(path, `\`, "/", -1)
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:] // From a file:///C:/... URL
+	}
+	return path
+}
+
+// Returns true if the map contains a mapping for the `from` prefix
+func (m PathMap) has(from string) bool {
+	from = strings.TrimRight(normalizeSeparators(from), "/")
+	for _, mapping := range m {
+		if strings.EqualFold(strings.TrimRight(normalizeSeparators(mapping.From), "/"), from) {
+			return true
+		}
+	}
+	return false
+}
+
+// Translate a library path using the mapping with the longest matching
+// prefix. Prefixes are compared case-insensitively, as the file systems used
+// by Windows and macOS are (by default) case-insensitive.
+//
+// The path is returned unmodified if no mapping applies.
+func (m PathMap) Translate(path string) string {
+	var match *PathMapping
+	var matchLen int
+
+	norm := normalizeSeparators(path)
+
+	for i, mapping := range m {
+		from := strings.TrimRight(normalizeSeparators(mapping.From), "/")
+		n := len(from)
+
+		if n == 0 || n > len(norm) || n <= matchLen || !strings.EqualFold(norm[:n], from) {
+			continue
+		}
+
+		// Only match complete path components
+		if len(norm) == n || norm[n] == '/' {
+			match = &m[i]
+			matchLen = n
+		}
+	}
+
+	if match == nil {
+		if isWindowsPath(path) {
+			Debugf("No path mapping applies to: %s\n", path)
+		}
+		return path
+	}
+
+	translated := filepath.Join(match.To, filepath.FromSlash(norm[matchLen:]))
+	Verbosef("Translated path %s -> %s\n", path, translated)
+	return translated
+}
+
+// Returns `path`, with the case of any components that do not exist corrected
+// to match an existing file or directory, case-insensitively. Components that
+// cannot be matched are left as-is.
+//
+// This accounts for libraries that have been copied between case-insensitive
+// and case-sensitive file systems (e.g., "My Library.Data" vs "My Library.DATA")
+func resolvePathCase(path string) string {
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path
+	}
+
+	dir := resolvePathCase(parent)
+	name := filepath.Base(path)
+
+	if entries, err := ioutil.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name(), name) {
+				return filepath.Join(dir, entry.Name())
+			}
+		}
+	}
+
+	return filepath.Join(dir, name)
+}
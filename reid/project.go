@@ -8,10 +8,13 @@
 package reid
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -27,10 +30,21 @@ const shortMiniTextThreshold = 2000
 type Project struct {
 	filename string
 
-	CreatedAt   string
-	ReidVersion string
-	DataDir     string
-	Entries     []ProjectEntry
+	SchemaVersion int // Version of the project file schema
+	CreatedAt     string
+	ReidVersion   string
+	DataDir       string  // Data root. See roots.go
+	LibraryRoot   string  // Directory containing the library's PDFs
+	PathMap       PathMap // Applied to PDF paths that do not exist
+
+	// Number of previous versions of the project file to keep when saving
+	// (as <file>.bak.1, <file>.bak.2, ...)
+	Backups int `json:",omitempty"`
+
+	AuthorAliases      AuthorAliases      // User-specified author name variants
+	PublicationAliases PublicationAliases // User-specified publication title variants
+
+	Entries []ProjectEntry
 
 	hashes  []RecordHash
 	hashMap map[RecordHash]*ProjectEntry
@@ -47,12 +61,44 @@ type Project struct {
 	auths   []ReducedStr
 	authMap map[string]pEntryList // map[reducedStr.reduced]pEntryList
 
+	// Identities of authors, used to map variants of names onto the
+	// canonical names used as authMap keys
+	authorIndex *AuthorIndex
+
+	// Likewise, for publication titles and pubMap
+	pubIndex *PublicationIndex
+
+	// Limits the entries converted or enriched (see RestrictToTags)
+	tagFilter TagFilter
+
+	// Contents of the project file as read, if these were upgraded from an
+	// older schema version. They are backed up before the file is first
+	// overwritten.
+	original        []byte
+	originalVersion int
 }
 
 type ProjectEntry struct {
 	Record    Record   // Record extracted from EndNote
-	Hash      string   // Record Hash, used to identify record
+	Hash      string   // Record identifier (see Record.Identity)
+	IDScheme  string   // Scheme used to derive Hash (IDScheme* constants)
 	MiniFiles []string // Minified text files used for searching
+
+	// Former identifiers (e.g., prior to correcting the record's metadata),
+	// which may still be used to select the entry
+	PrevHashes []string `json:",omitempty"`
+
+	Proposals []Proposal // Proposed values for empty metadata fields
+
+	// How each of the PDFs was converted (see ConversionInfo)
+	Conversions []ConversionInfo `json:",omitempty"`
+
+	// Project file the entry was merged from, if it is not from this
+	// project's library (see Project.Merge)
+	MergedFrom string `json:",omitempty"`
+
+	Tags  []string `json:",omitempty"` // User-defined collections (see tags.go)
+	Notes string   `json:",omitempty"` // Free-text notes
 }
 
 type pEntryList []*ProjectEntry
@@ -72,10 +118,12 @@ func NewProject(dataDir string, records []Record) (*Project, error) {
 	}
 
 	project.DataDir = dataDir
+	project.LibraryRoot = libraryRootOf(records)
 
 	for _, record := range records {
-		project.Entries = append(project.Entries, ProjectEntry{Record: record, Hash: record.HashString(), MiniFiles: []string{}})
+		project.Entries = append(project.Entries, ProjectEntry{Record: record, MiniFiles: []string{}})
 	}
+	project.assignIdentities()
 
 	return project, nil
 }
@@ -96,36 +144,105 @@ func (p *Project) Save(filename string) error {
 		return err
 	}
 
-	outfile, err := os.Create(filename)
+	if p.original != nil && filename == p.filename {
+		backup, err := backupProjectFile(filename, p.original, p.originalVersion)
+		if err != nil {
+			return fmt.Errorf("Failed to back up %s prior to upgrading it: %s", filename, err)
+		}
+
+		Infof("Upgrading project file %s from schema version %d to %d. "+
+			"The original has been saved to %s\n", filename, p.originalVersion,
+			ProjectSchemaVersion, backup)
+		p.original = nil
+	}
+
+	p.SchemaVersion = ProjectSchemaVersion
+	p.CreatedAt = time.Now().String()
+
+	portable, err := p.portableCopy(filename)
 	if err != nil {
 		return err
 	}
-	defer outfile.Close()
 
-	enc := json.NewEncoder(outfile)
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
 	enc.SetIndent("", "  ")
+	if err = enc.Encode(portable); err != nil {
+		return err
+	}
 
-	p.CreatedAt = time.Now().String()
-	return enc.Encode(p)
+	return writeFileAtomic(filename, data.Bytes(), p.Backups)
 }
 
-func LoadProject(filename string) (*Project, error) {
+// Read and decode a project file, upgrading it if necessary. Returns the
+// project and the schema version the file was written with.
+func readProject(filename string) (*Project, int, error) {
 	var project = new(Project)
 	project.filename = filename
 
-	infile, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	upgraded, version, err := upgradeProjectFile(filename, data)
+	if err != nil {
+		return nil, version, err
+	}
+
+	err = json.Unmarshal(upgraded, project)
+	if err != nil {
+		return nil, version, err
+	}
+
+	if version != ProjectSchemaVersion {
+		project.original = data
+		project.originalVersion = version
 	}
-	defer infile.Close()
 
-	dec := json.NewDecoder(infile)
-	err = dec.Decode(project)
+	return project, version, project.resolvePaths(filename)
+}
+
+/*
+ * Load a project file. Files written by older versions of reid are upgraded
+ * to the current schema.
+ *
+ * If `exclusive` is set, the caller must hold an exclusive lock on the file
+ * (see LockProject), and an upgraded project is saved, after saving a backup
+ * of the original. Otherwise, it is upgraded only in memory, and is saved
+ * (and backed up) when it is next saved by a process that modifies it.
+ */
+func LoadProject(filename string, exclusive bool) (*Project, error) {
+	project, version, err := readProject(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	return project.scan()
+	if _, err = project.scan(); err != nil {
+		return nil, err
+	}
+
+	if version != ProjectSchemaVersion && exclusive {
+		Verbosef("Saving upgraded project file: %s\n", filename)
+		if err = project.Save(filename); err != nil {
+			return nil, err
+		}
+	} else if version != ProjectSchemaVersion {
+		Verbosef("Not saving upgraded project file, as it is in use read-only: %s\n", filename)
+	}
+
+	return project, nil
+}
+
+/*
+ * Load a project file without checking that its data directory or PDFs
+ * exist, nor populating its look-up tables. This is intended only for
+ * repairing a project that has been moved (see SetRoots). Upgraded projects
+ * are not saved, but are backed up when the caller next saves them.
+ */
+func LoadProjectUnchecked(filename string) (*Project, error) {
+	project, _, err := readProject(filename)
+	return project, err
 }
 
 // Sanity check the state of the project, report and drop bad entries,
@@ -135,7 +252,8 @@ func (p *Project) scan() (*Project, error) {
 	// Does our data dir exist?
 	if _, err := os.Stat(p.DataDir); err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("Data directory does not exist: %s\n", p.DataDir)
+			return nil, fmt.Errorf("Data directory does not exist: %s\n"+
+				"If the project has been moved, re-point its data root (see reid-project roots).", p.DataDir)
 		} else {
 			return nil, err
 		}
@@ -160,7 +278,33 @@ func (p *Project) scan() (*Project, error) {
 	p.pubMap = make(map[string]pEntryList, len(p.Entries))
 	p.pubs = make([]ReducedStr, 0, len(p.Entries))
 
+	var authorNames []string
+	var records []Record = make([]Record, len(p.Entries))
 	for i, entry := range p.Entries {
+		authorNames = append(authorNames, entry.Record.Authors...)
+		records[i] = entry.Record
+	}
+	p.authorIndex = NewAuthorIndex(authorNames, p.AuthorAliases)
+	p.pubIndex = NewPublicationIndex(records, p.PublicationAliases)
+
+	var missingPDFs int
+	for i, entry := range p.Entries {
+		hash, err := StringToRecordHash(entry.Hash)
+		if err != nil {
+			Warnf("Invalid identifier (%s). Reassigning identifier for: %s\n",
+				entry.Hash, entry.Record.String())
+			p.updateIdentity(&p.Entries[i])
+			entry = p.Entries[i]
+			hash, _ = StringToRecordHash(entry.Hash)
+		}
+
+		if other, exists := p.hashMap[hash]; exists {
+			Errorf("Identifier %s is used by more than one entry. Skipping: %s\n",
+				entry.Hash, entry.Record.String())
+			Debugf(" `- Also used by: %s\n", other.Record.String())
+			continue
+		}
+
 		// Look for suspicious minifiles that might indicate bad conversion
 		if len(entry.MiniFiles) != 0 {
 			for _, f := range entry.MiniFiles {
@@ -173,13 +317,30 @@ func (p *Project) scan() (*Project, error) {
 			}
 		}
 
+		// Incomplete records are retained only for reporting purposes, and
+		// for metadata enrichment. They may only be looked up via their hash.
+		if entry.Record.IsIncomplete() {
+			Verbosef("Not loading incomplete entry (%s): %s\n",
+				strings.Join(entry.Record.Status, ", "), entry.Record.String())
+			p.hashMap[hash] = &p.Entries[i]
+			continue
+		}
+
 		// Do the PDFs exist?
 		skip := false
-		for _, pdf := range entry.Record.PDFs {
+		for j, pdf := range entry.Record.PDFs {
 			if _, err := os.Stat(pdf); err != nil {
 				if os.IsNotExist(err) {
+					// Perhaps the library was exported from another machine
+					if local, found := p.findPDF(pdf); found {
+						Debugf("Located PDF %s at %s\n", pdf, local)
+						p.Entries[i].Record.PDFs[j] = local
+						continue
+					}
+
 					Errorf("PDF does not exist: %s\n", pdf)
 					Debugf(" `- Skipping Record: %s\n", entry.Record.String())
+					missingPDFs++
 					skip = true
 					break
 				}
@@ -192,7 +353,6 @@ func (p *Project) scan() (*Project, error) {
 			Verbosef("Loading entry for %s\n", entry.Record.String())
 		}
 
-		hash := entry.Record.Hash()
 		p.hashMap[hash] = &p.Entries[i]
 		p.hashes = append(p.hashes, hash)
 		Verbosef("Added entry to hashMap[%s]\n", hash.String())
@@ -220,12 +380,19 @@ func (p *Project) scan() (*Project, error) {
 			Verbosef("Created entry in new titleMap: %s\n", title.Reduced)
 		}
 
+		entryAuths := make(map[string]bool, len(entry.Record.Authors))
 		for _, auth := range entry.Record.Authors {
-			auth, err := NewReducedStr(auth)
+			auth, err := NewReducedStr(p.authorIndex.Canonical(auth))
 			if err != nil {
 				return nil, err
 			}
 
+			// Different variants of the same author's name in one record
+			if entryAuths[auth.Reduced] {
+				continue
+			}
+			entryAuths[auth.Reduced] = true
+
 			if recordsByAuth, exists := p.authMap[auth.Reduced]; exists {
 				p.authMap[auth.Reduced] = append(recordsByAuth, &p.Entries[i])
 				Verbosef("Appended entry to authMap: %s\n", auth.Reduced)
@@ -236,7 +403,7 @@ func (p *Project) scan() (*Project, error) {
 			}
 		}
 
-		pub, err := NewReducedStr(entry.Record.Publication)
+		pub, err := NewReducedStr(p.pubIndex.Canonical(entry.Record.Publication))
 		if err != nil {
 			return nil, err
 		}
@@ -251,9 +418,66 @@ func (p *Project) scan() (*Project, error) {
 		}
 	}
 
+	if missingPDFs != 0 {
+		Warnf("Skipped %d entries with missing PDFs. If the library has been moved, "+
+			"re-point the project's library root (see reid-project roots).\n", missingPDFs)
+	}
+
+	// Entries may also be looked up via their former identifiers, provided
+	// these are not the current identifiers of other entries
+	for i, entry := range p.Entries {
+		current, err := StringToRecordHash(entry.Hash)
+		if err != nil || p.hashMap[current] != &p.Entries[i] {
+			continue // Entry was skipped
+		}
+
+		for _, prev := range entry.PrevHashes {
+			if hash, err := StringToRecordHash(prev); err == nil {
+				if _, exists := p.hashMap[hash]; !exists {
+					p.hashMap[hash] = &p.Entries[i]
+				}
+			}
+		}
+	}
+
 	return p, nil
 }
 
+// Remove the entries at the specified indices of p.Entries, and repopulate
+// look-up tables. The project is not saved.
+func (p *Project) RemoveEntries(indices []int) error {
+	remove := make(map[int]bool, len(indices))
+	for _, i := range indices {
+		if i < 0 || i >= len(p.Entries) {
+			return fmt.Errorf("Invalid entry index: %d", i)
+		}
+		remove[i] = true
+	}
+
+	entries := make([]ProjectEntry, 0, len(p.Entries)-len(remove))
+	for i := range p.Entries {
+		if remove[i] {
+			Verbosef("Removing entry: %s\n", p.Entries[i].Record.String())
+		} else {
+			entries = append(entries, p.Entries[i])
+		}
+	}
+
+	p.Entries = entries
+	_, err := p.scan()
+	return err
+}
+
+// Attempt to locate a PDF that does not exist at the specified path,
+// by applying the project's path mappings and correcting the path's case.
+func (p *Project) findPDF(pdf string) (string, bool) {
+	local := resolvePathCase(normalizeSeparators(p.PathMap.Translate(pdf)))
+	if _, err := os.Stat(local); err != nil {
+		return pdf, false
+	}
+	return local, true
+}
+
 func (p *Project) Years() []int {
 	var years []int = make([]int, len(p.years))
 	for i, year := range p.years {
@@ -270,6 +494,36 @@ func (p *Project) Authors() []string {
 	return authors
 }
 
+// Returns the entries with an author matching `name`. See AuthorIndex.Match()
+func (p *Project) entriesByAuthor(name string) pEntryList {
+	var entries pEntryList
+	for _, cluster := range p.authorIndex.Match(name) {
+		entries = append(entries, p.authMap[Reduce(cluster.Name)]...)
+	}
+	return entries
+}
+
+// Returns the entries with a publication matching `title`.
+// See PublicationIndex.Match()
+func (p *Project) entriesByPublication(title string) pEntryList {
+	var entries pEntryList
+	for _, canonical := range p.pubIndex.Match(title) {
+		entries = append(entries, p.pubMap[Reduce(canonical)]...)
+	}
+	return entries
+}
+
+// Returns the canonical title of a publication
+func (p *Project) CanonicalPublication(title string) string {
+	return p.pubIndex.Canonical(title)
+}
+
+// Returns the author identities present in the project, and the variants of
+// their names
+func (p *Project) AuthorClusters() []AuthorCluster {
+	return p.authorIndex.Clusters()
+}
+
 func (p *Project) Publications() []string {
 	var publications []string = make([]string, len(p.pubs))
 	for i, publication := range p.pubs {
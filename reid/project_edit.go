@@ -0,0 +1,369 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Inspection and editing of project entries
+ *
+ * Edits re-populate the project's look-up tables (see Project.scan), so that
+ * entries may still be located by their title, authors, publication, and
+ * identifier afterwards. Projects are not saved by these functions.
+ */
+
+package reid
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Record fields that may be set via Project.SetField, in addition to those
+// for which values may be proposed (see Field* constants)
+const (
+	FieldTitle    = "Title"
+	FieldLanguage = "Language"
+	FieldVolume   = "Volume"
+	FieldIssue    = "Issue"
+	FieldPages    = "Pages"
+	FieldKeywords = "Keywords"
+	FieldLabel    = "Label"
+)
+
+// Names of the fields that may be set via Project.SetField
+var EditableFields = []string{
+	FieldTitle, FieldAuthors, FieldPublication, FieldYear, FieldDOI,
+	FieldLanguage, FieldVolume, FieldIssue, FieldPages, FieldKeywords, FieldLabel,
+}
+
+// Conversion states of project entries (see Project.EntryStatus)
+const (
+	EntryConverted   = "converted"
+	EntryStale       = "stale" // Converted, but the PDFs have since changed
+	EntryUnconverted = "not converted"
+	EntryNotLoaded   = "not loaded" // Skipped when loading (e.g., missing PDF)
+)
+
+// Summary of a project file
+type ProjectInfo struct {
+	Filename      string
+	SchemaVersion int
+	ReidVersion   string
+	CreatedAt     string
+	DataDir       string
+	LibraryRoot   string
+	Backups       int
+
+	Entries   int      // Total number of entries
+	NotLoaded int      // Entries skipped when loading (e.g., missing PDFs)
+	Stale     int      // Converted entries whose PDFs have since changed
+	OCR       int      // PDFs converted using OCR
+	Coverage  Coverage // Breakdown of the entries' conversion status
+}
+
+func (p *Project) Info() ProjectInfo {
+	info := ProjectInfo{
+		Filename:      p.filename,
+		SchemaVersion: p.SchemaVersion,
+		ReidVersion:   p.ReidVersion,
+		CreatedAt:     p.CreatedAt,
+		DataDir:       p.DataDir,
+		LibraryRoot:   p.LibraryRoot,
+		Backups:       p.Backups,
+		Entries:       len(p.Entries),
+		Coverage:      p.Coverage(),
+	}
+
+	for i := range p.Entries {
+		switch p.EntryStatus(&p.Entries[i]) {
+		case EntryNotLoaded:
+			info.NotLoaded++
+		case EntryStale:
+			info.Stale++
+		}
+
+		for _, c := range p.Entries[i].Conversions {
+			if c.Method == ConversionOCR {
+				info.OCR++
+			}
+		}
+	}
+
+	return info
+}
+
+func (i ProjectInfo) Pretty(eol string) string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "Project file:   %s%s", i.Filename, eol)
+	fmt.Fprintf(&b, "Schema version: %d%s", i.SchemaVersion, eol)
+	fmt.Fprintf(&b, "Saved by:       reid %s%s", i.ReidVersion, eol)
+	fmt.Fprintf(&b, "Last saved:     %s%s", i.CreatedAt, eol)
+	fmt.Fprintf(&b, "Data root:      %s%s", i.DataDir, eol)
+	fmt.Fprintf(&b, "Library root:   %s%s", i.LibraryRoot, eol)
+	fmt.Fprintf(&b, "Backups:        %d%s", i.Backups, eol)
+	fmt.Fprintf(&b, "Not loaded:     %d%s", i.NotLoaded, eol)
+	fmt.Fprintf(&b, "Stale:          %d%s", i.Stale, eol)
+	fmt.Fprintf(&b, "PDFs OCR'd:     %d%s", i.OCR, eol)
+	b.WriteString(i.Coverage.Pretty(eol, true))
+
+	return b.String()
+}
+
+// Returns true if the entry was loaded into the project's look-up tables
+func (p *Project) isLoaded(e *ProjectEntry) bool {
+	hash, err := StringToRecordHash(e.Hash)
+	return err == nil && p.hashMap[hash] == e
+}
+
+/*
+ * Returns the conversion status of an entry: EntryConverted, EntryStale,
+ * EntryUnconverted, or EntryNotLoaded. For incomplete entries, the reasons they are incomplete
+ * are returned instead.
+ */
+func (p *Project) EntryStatus(e *ProjectEntry) string {
+	switch {
+	case e.Record.IsIncomplete():
+		return strings.Join(e.Record.Status, ", ")
+	case !p.isLoaded(e):
+		return EntryNotLoaded
+	case len(e.MiniFiles) != 0:
+		if stale, _ := p.IsStale(e); stale {
+			return EntryStale
+		}
+		return EntryConverted
+	default:
+		return EntryUnconverted
+	}
+}
+
+/*
+ * Returns the entries matching any of the specified records (see
+ * RecordToConvert), or all entries if `records` is empty. Entries are returned
+ * in the order in which they appear in the project.
+ */
+func (p *Project) ListEntries(records []RecordToConvert) ([]*ProjectEntry, error) {
+	selected, err := p.selectEntries(records)
+	if err != nil {
+		return nil, err
+	}
+
+	include := make(map[*ProjectEntry]bool, len(selected))
+	for _, e := range selected {
+		include[e] = true
+	}
+
+	entries := make([]*ProjectEntry, 0, len(selected))
+	for i := range p.Entries {
+		if include[&p.Entries[i]] {
+			entries = append(entries, &p.Entries[i])
+		}
+	}
+
+	return entries, nil
+}
+
+/*
+ * Returns the index of the entry with the specified identifier. Former
+ * identifiers may also be used. Unlike look-ups performed when converting
+ * or searching, this includes entries that were not loaded.
+ */
+func (p *Project) FindEntry(id string) (int, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+
+	for i := range p.Entries {
+		if p.Entries[i].Hash == id {
+			return i, nil
+		}
+	}
+
+	for i := range p.Entries {
+		for _, prev := range p.Entries[i].PrevHashes {
+			if prev == id {
+				return i, nil
+			}
+		}
+	}
+
+	return -1, fmt.Errorf("No entry has the identifier: %s", id)
+}
+
+// Remove the entries with the specified identifiers, returning their records
+func (p *Project) RemoveEntriesByID(ids []string) ([]Record, error) {
+	var indices []int
+	var removed []Record
+
+	for _, id := range ids {
+		i, err := p.FindEntry(id)
+		if err != nil {
+			return nil, err
+		}
+		indices = append(indices, i)
+		removed = append(removed, p.Entries[i].Record)
+	}
+
+	return removed, p.RemoveEntries(indices)
+}
+
+// Recompute the reasons a record is incomplete, after modifying it.
+// Records removed from the library remain flagged as such.
+func (r *Record) updateStatus() {
+	removed := r.isRemoved()
+	r.Status = r.incompleteStatus()
+	if removed {
+		r.Status = append(r.Status, StatusRemoved)
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); len(item) != 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Returns the canonical name of an editable field, matched case-insensitively
+func editableField(name string) (string, error) {
+	for _, field := range EditableFields {
+		if strings.EqualFold(field, name) {
+			return field, nil
+		}
+	}
+	return "", fmt.Errorf("Field %s may not be set. Fields that may be set: %s",
+		name, strings.Join(EditableFields, ", "))
+}
+
+/*
+ * Set a field of an entry's record, for correcting its metadata. Multiple
+ * authors or keywords are separated by semicolons. An empty value clears the
+ * field. Returns the updated entry.
+ *
+ * The entry's incomplete status is updated, and it is assigned a new
+ * identifier if the one it is identified by changed.
+ */
+func (p *Project) SetField(id, name, value string) (*ProjectEntry, error) {
+	i, err := p.FindEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	e := &p.Entries[i]
+
+	field, err := editableField(name)
+	if err != nil {
+		return nil, err
+	}
+
+	value = strings.TrimSpace(value)
+	r := &e.Record
+
+	switch field {
+	case FieldTitle:
+		if len(value) == 0 {
+			return nil, fmt.Errorf("An entry's title may not be empty")
+		}
+		r.Title = value
+	case FieldYear:
+		if len(value) == 0 {
+			r.Year = 0
+		} else if year, err := strconv.Atoi(value); err != nil || !plausibleYear(year) {
+			return nil, fmt.Errorf("Invalid year: %s", value)
+		} else {
+			r.Year = year
+		}
+	case FieldLanguage:
+		r.Language = value
+	case FieldVolume:
+		r.Volume = value
+	case FieldIssue:
+		r.Issue = value
+	case FieldPages:
+		r.Pages = value
+	case FieldKeywords:
+		r.Keywords = splitList(value)
+	case FieldLabel:
+		r.Label = value
+	default:
+		if err := r.setField(field, value); err != nil {
+			return nil, err
+		}
+	}
+
+	Verbosef("Set %s of %s to: %s\n", field, e.Hash, value)
+
+	r.updateStatus()
+	p.updateIdentity(e)
+
+	if _, err = p.scan(); err != nil {
+		return nil, err
+	}
+
+	return &p.Entries[i], nil
+}
+
+func writeEntryList(b *bytes.Buffer, what string, items []string, eol string) {
+	if len(items) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "%-14s %s%s", what+":", items[0], eol)
+	for _, item := range items[1:] {
+		fmt.Fprintf(b, "%-14s %s%s", "", item, eol)
+	}
+}
+
+// Describe an entry and its record in full
+func (p *Project) PrettyEntry(e *ProjectEntry, eol string) string {
+	var b bytes.Buffer
+	r := &e.Record
+
+	fmt.Fprintf(&b, "Identifier:    %s (%s)%s", e.Hash, e.IDScheme, eol)
+	writeEntryList(&b, "Former IDs", e.PrevHashes, eol)
+	fmt.Fprintf(&b, "Status:        %s%s", p.EntryStatus(e), eol)
+	fmt.Fprintf(&b, "Title:         %s%s", r.Title, eol)
+	writeEntryList(&b, "Authors", r.Authors, eol)
+	fmt.Fprintf(&b, "Publication:   %s%s", r.Publication, eol)
+	writeEntryList(&b, "Alt. pubs", r.AltPublications, eol)
+	fmt.Fprintf(&b, "Year:          %d%s", r.Year, eol)
+
+	for _, field := range []struct{ name, value string }{
+		{"Volume", r.Volume}, {"Issue", r.Issue}, {"Pages", r.Pages},
+		{"DOI", r.DOI}, {"Language", r.Language}, {"Type", r.RefType},
+		{"Label", r.Label}, {"Accession", r.AccessionNum},
+	} {
+		if len(field.value) != 0 {
+			fmt.Fprintf(&b, "%-14s %s%s", field.name+":", field.value, eol)
+		}
+	}
+
+	if r.RecNumber > 0 {
+		fmt.Fprintf(&b, "Record number: %d%s", r.RecNumber, eol)
+	}
+
+	if len(e.MergedFrom) != 0 {
+		fmt.Fprintf(&b, "Merged from:   %s%s", e.MergedFrom, eol)
+	}
+
+	writeEntryList(&b, "Keywords", r.Keywords, eol)
+	writeEntryList(&b, "URLs", r.URLs, eol)
+	writeEntryList(&b, "PDFs", r.PDFs, eol)
+	writeEntryList(&b, "Minified text", e.MiniFiles, eol)
+	b.WriteString(e.prettyConversions(eol))
+
+	if stale, reason := p.IsStale(e); stale {
+		fmt.Fprintf(&b, "Stale:         %s%s", reason, eol)
+	}
+
+	for _, prop := range e.Proposals {
+		fmt.Fprintf(&b, "Proposed:      %s%s", prop.String(), eol)
+	}
+
+	writeEntryList(&b, "Tags", e.Tags, eol)
+	if len(e.Notes) != 0 {
+		fmt.Fprintf(&b, "Notes:         %s%s", e.Notes, eol)
+	}
+
+	return b.String()
+}
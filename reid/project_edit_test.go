@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Tests of project entry look-up and editing
+ */
+
+package reid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const formerID = "0123456789abcdef0123456789abcdef"
+
+/*
+ * Create a project with entries identified by record number ("A"), metadata
+ * hash ("B"), and DOI ("C"). "B" was formerly identified by `formerID`.
+ */
+func newEditProject(t *testing.T, dir string) *Project {
+	records := []Record{
+		testRecord("A", 1, ""),
+		testRecord("B", 0, ""),
+		testRecord("C", 0, "10.1000/c"),
+	}
+
+	for i := range records {
+		records[i].PDFs = []string{filepath.Join(dir, "PDF", records[i].Title+".pdf")}
+	}
+
+	p, err := NewProject(filepath.Join(dir, "data"), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.MkdirAll(p.DataDir, 0770); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Entries[1].PrevHashes = []string{formerID}
+	return p
+}
+
+func TestFindEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The current identifier of "C" was formerly that of "A"
+	p := newEditProject(t, dir)
+	p.Entries[0].PrevHashes = []string{p.Entries[2].Hash}
+
+	var findTests = []struct {
+		name   string
+		id     string
+		expect int // Index of the expected entry, or -1 for an error
+	}{
+		{"current", p.Entries[1].Hash, 1},
+		{"case and space", "  " + strings.ToUpper(p.Entries[1].Hash) + "\n", 1},
+		{"former", formerID, 1},
+		{"current preferred over former", p.Entries[2].Hash, 2},
+		{"unknown", "fedcba9876543210fedcba9876543210", -1},
+		{"empty", "", -1},
+	}
+
+	for _, test := range findTests {
+		t.Run(test.name, func(t *testing.T) {
+			i, err := p.FindEntry(test.id)
+			if test.expect < 0 {
+				if err == nil {
+					t.Errorf("Expected an error, got entry %d", i)
+				}
+			} else if err != nil {
+				t.Error(err)
+			} else if i != test.expect {
+				t.Errorf("Got entry %d, expected %d", i, test.expect)
+			}
+		})
+	}
+}
+
+var setFieldTests = []struct {
+	name      string
+	entry     int    // Index of the entry to modify
+	former    bool   // Select the entry by its former identifier
+	field     string // Field name, as given by the user
+	value     string
+	expectErr bool
+
+	check  func(r *Record) bool // Checks the updated record
+	scheme string               // Expected identity scheme
+	status []string             // Expected Record.Status
+}{
+	{
+		name: "title", entry: 0, field: "title", value: " A (corrected) ",
+		check:  func(r *Record) bool { return r.Title == "A (corrected)" },
+		scheme: IDSchemeRecNumber,
+	},
+	{
+		name: "title by former identifier", entry: 1, former: true, field: "Title", value: "B (corrected)",
+		check:  func(r *Record) bool { return r.Title == "B (corrected)" },
+		scheme: IDSchemeHash,
+	},
+	{
+		name: "DOI added", entry: 1, field: "DOI", value: "10.1000/b",
+		check:  func(r *Record) bool { return r.DOI == "10.1000/b" },
+		scheme: IDSchemeDOI,
+	},
+	{
+		name: "DOI of another entry", entry: 1, field: "DOI", value: "10.1000/C",
+		check:  func(r *Record) bool { return r.DOI == "10.1000/C" },
+		scheme: IDSchemeHash,
+	},
+	{
+		name: "DOI cleared", entry: 2, field: "doi", value: "",
+		check:  func(r *Record) bool { return len(r.DOI) == 0 },
+		scheme: IDSchemeHash,
+	},
+	{
+		name: "authors", entry: 0, field: "Authors", value: "Smith, J.; ; Doe, J.",
+		check: func(r *Record) bool {
+			return reflect.DeepEqual(r.Authors, []string{"Smith, J.", "Doe, J."})
+		},
+		scheme: IDSchemeRecNumber,
+	},
+	{
+		name: "authors cleared", entry: 0, field: "Authors", value: "",
+		check:  func(r *Record) bool { return len(r.Authors) == 0 },
+		scheme: IDSchemeRecNumber,
+		status: []string{StatusMissingAuthors},
+	},
+	{
+		name: "year cleared", entry: 1, field: "Year", value: "",
+		check:  func(r *Record) bool { return r.Year == 0 },
+		scheme: IDSchemeHash,
+		status: []string{StatusMissingYear},
+	},
+	{name: "invalid year", entry: 1, field: "Year", value: "20x1", expectErr: true},
+	{name: "implausible year", entry: 1, field: "Year", value: "1066", expectErr: true},
+	{name: "empty title", entry: 0, field: "Title", value: " ", expectErr: true},
+	{name: "not editable", entry: 0, field: "RecNumber", value: "2", expectErr: true},
+}
+
+func TestSetField(t *testing.T) {
+	for _, test := range setFieldTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			p := newEditProject(t, dir)
+			original := p.Entries[test.entry]
+			original.Record.Authors = append([]string{}, original.Record.Authors...)
+
+			id := original.Hash
+			if test.former {
+				id = formerID
+			}
+
+			e, err := p.SetField(id, test.field, test.value)
+			if test.expectErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+
+				if !reflect.DeepEqual(p.Entries[test.entry].Record, original.Record) {
+					t.Errorf("Record was modified: %s", p.Entries[test.entry].Record.String())
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if e != &p.Entries[test.entry] {
+				t.Fatal("Wrong entry was modified")
+			}
+
+			if !test.check(&e.Record) {
+				t.Errorf("Unexpected record: %s", e.Record.String())
+			}
+
+			if !reflect.DeepEqual(e.Record.Status, test.status) {
+				t.Errorf("Status: got %v, expected %v", e.Record.Status, test.status)
+			}
+
+			if e.IDScheme != test.scheme {
+				t.Errorf("Scheme: got %s, expected %s", e.IDScheme, test.scheme)
+			}
+
+			// The entry may still be selected by its previous identifiers
+			ids := append([]string{original.Hash}, original.PrevHashes...)
+			for _, id := range ids {
+				if i, err := p.FindEntry(id); err != nil || i != test.entry {
+					t.Errorf("%s no longer selects the entry (%v)", id, err)
+				}
+			}
+
+			for i := range p.Entries {
+				if i != test.entry && p.Entries[i].Hash == e.Hash {
+					t.Errorf("Identifier %s is shared with entry %d", e.Hash, i)
+				}
+			}
+		})
+	}
+}
+
+// Records removed from the library remain flagged as such when edited
+func TestSetFieldRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := newEditProject(t, dir)
+	p.Entries[0].Record.Status = []string{StatusRemoved}
+
+	e, err := p.SetField(p.Entries[0].Hash, FieldYear, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(e.Record.Status, []string{StatusMissingYear, StatusRemoved}) {
+		t.Errorf("Got status %v", e.Record.Status)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Safe project file updates
+ *
+ * Project files are written to a temporary file that is then renamed over the
+ * original, so that an interrupted save (e.g., Ctrl-C during reid-convert)
+ * never leaves a truncated project file behind. Optionally, a number of
+ * previous versions of the file are kept as rolling backups.
+ *
+ * Concurrent use of a project is coordinated via an advisory lock on a
+ * separate lock file (the project file itself is replaced on every save).
+ * Processes that modify a project hold an exclusive lock, while those that
+ * only read it hold a shared lock.
+ */
+
+package reid
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Returns the name of the n'th most recent backup of a project file
+func projectBackupName(filename string, n int) string {
+	return fmt.Sprintf("%s.bak.%d", filename, n)
+}
+
+// Shift the existing backups of a file, discarding the oldest, and back up the
+// current version of the file as the most recent.
+func rotateBackups(filename string, count int) error {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+
+	os.Remove(projectBackupName(filename, count))
+	for n := count - 1; n >= 1; n-- {
+		if err := os.Rename(projectBackupName(filename, n), projectBackupName(filename, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	Debugf("Backing up %s to %s\n", filename, projectBackupName(filename, 1))
+	return copyFile(filename, projectBackupName(filename, 1))
+}
+
+/*
+ * Replace the contents of `filename` with `data`, such that the file contains
+ * either its previous or new contents should this be interrupted. If `backups`
+ * is non-zero, this many previous versions of the file are retained.
+ */
+func writeFileAtomic(filename string, data []byte, backups int) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+
+	// Remove the temporary file if anything goes wrong
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	mode := os.FileMode(0640)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+
+	if err == nil && backups > 0 {
+		err = rotateBackups(filename, backups)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+
+	committed = true
+	return nil
+}
+
+var errLocked = errors.New("File is locked")
+
+// An advisory lock on a project file
+type ProjectLock struct {
+	file *os.File
+}
+
+/*
+ * Locks that have not been released. These are referenced here so that they
+ * are held for the life of the process, even when callers discard them;
+ * otherwise, the lock file would be closed (releasing the lock) when its
+ * *os.File is garbage collected.
+ */
+var heldLocks = struct {
+	sync.Mutex
+	locks map[*ProjectLock]bool
+}{locks: make(map[*ProjectLock]bool)}
+
+// Name of the lock file associated with a project file
+func projectLockName(filename string) string {
+	return filename + ".lock"
+}
+
+/*
+ * Lock a project file, for exclusive use if the project is to be modified, or
+ * for shared use otherwise. This does not wait for other processes to
+ * release the project; an error is returned if it is in use.
+ *
+ * The lock is released when Unlock() is called, or the process exits.
+ */
+func LockProject(filename string, exclusive bool) (*ProjectLock, error) {
+	lockName := projectLockName(filename)
+
+	if err := os.MkdirAll(filepath.Dir(lockName), 0770); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(lockName, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lockFile(f, exclusive); err != nil {
+		f.Close()
+		if err == errLocked {
+			what := "read"
+			if exclusive {
+				what = "modified"
+			}
+			return nil, fmt.Errorf("%s is in use by another reid process, and cannot be %s "+
+				"until it finishes. (Lock file: %s)", filename, what, lockName)
+		}
+		return nil, err
+	}
+
+	Debugf("Locked %s (exclusive=%v)\n", lockName, exclusive)
+	lock := &ProjectLock{file: f}
+
+	heldLocks.Lock()
+	heldLocks.locks[lock] = true
+	heldLocks.Unlock()
+
+	return lock, nil
+}
+
+func (l *ProjectLock) Unlock() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	heldLocks.Lock()
+	delete(heldLocks.locks, l)
+	heldLocks.Unlock()
+
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
@@ -0,0 +1,319 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Tests of safe project file updates and locking
+ */
+
+package reid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+var writeFileAtomicTests = []struct {
+	name     string
+	existing string      // Prior contents of the file, if any
+	mode     os.FileMode // Mode of the existing file
+	backups  int
+	prior    []string // Contents of prior backups, most recent first ("" if absent)
+	expect   []string // Expected contents of backups, most recent first ("" if absent)
+}{
+	{name: "new file"},
+	{name: "new file, with backups", backups: 2},
+	{name: "existing file", existing: "v1", mode: 0600},
+	{
+		name:     "first backup",
+		existing: "v1",
+		mode:     0640,
+		backups:  2,
+		expect:   []string{"v1"},
+	},
+	{
+		name:     "backups shifted",
+		existing: "v2",
+		mode:     0640,
+		backups:  3,
+		prior:    []string{"v1"},
+		expect:   []string{"v2", "v1"},
+	},
+	{
+		name:     "oldest backup discarded",
+		existing: "v3",
+		mode:     0640,
+		backups:  2,
+		prior:    []string{"v2", "v1"},
+		expect:   []string{"v3", "v2"},
+	},
+	{
+		name:     "missing backup",
+		existing: "v3",
+		mode:     0640,
+		backups:  3,
+		prior:    []string{"", "v1"},
+		expect:   []string{"v3", "", "v1"},
+	},
+	{
+		name:     "backups disabled",
+		existing: "v2",
+		mode:     0640,
+		prior:    []string{"v1"},
+		expect:   []string{"v1"},
+	},
+}
+
+// Returns the contents of a file, or an empty string if it does not exist
+func readTestFile(t *testing.T, filename string) string {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Returns the names of the files in `dir`
+func dirNames(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	for _, test := range writeFileAtomicTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			filename := filepath.Join(dir, "project.json")
+			if len(test.existing) != 0 {
+				if err = ioutil.WriteFile(filename, []byte(test.existing), test.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var expectNames []string
+			for i, contents := range test.prior {
+				if len(contents) != 0 {
+					writeTestFile(t, dir, filepath.Base(projectBackupName(filename, i+1)), contents)
+				}
+			}
+
+			if err = writeFileAtomic(filename, []byte("new"), test.backups); err != nil {
+				t.Fatal(err)
+			}
+
+			if data := readTestFile(t, filename); data != "new" {
+				t.Errorf("Got contents \"%s\"", data)
+			}
+
+			mode := test.mode
+			if len(test.existing) == 0 {
+				mode = 0640
+			}
+
+			if info, err := os.Stat(filename); err != nil {
+				t.Fatal(err)
+			} else if info.Mode().Perm() != mode {
+				t.Errorf("Got mode %v, expected %v", info.Mode().Perm(), mode)
+			}
+
+			for i, expect := range test.expect {
+				backup := projectBackupName(filename, i+1)
+				if got := readTestFile(t, backup); got != expect {
+					t.Errorf("%s: got \"%s\", expected \"%s\"", backup, got, expect)
+				}
+				if len(expect) != 0 {
+					expectNames = append(expectNames, filepath.Base(backup))
+				}
+			}
+
+			// Only the file and its backups remain
+			expectNames = append([]string{"project.json"}, expectNames...)
+			if names := dirNames(t, dir); !reflect.DeepEqual(names, expectNames) {
+				t.Errorf("Got files %v, expected %v", names, expectNames)
+			}
+		})
+	}
+}
+
+// A failed update leaves the file as it was, without a temporary file
+func TestWriteFileAtomicFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A non-empty directory cannot be replaced by a file
+	filename := filepath.Join(dir, "project.json")
+	writeTestFile(t, filename, "entry", "contents")
+
+	if err = writeFileAtomic(filename, []byte("new"), 0); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if names := dirNames(t, dir); !reflect.DeepEqual(names, []string{"project.json"}) {
+		t.Errorf("Got files %v", names)
+	}
+
+	if data := readTestFile(t, filepath.Join(filename, "entry")); data != "contents" {
+		t.Error("Existing contents were modified")
+	}
+}
+
+// Projects keep the configured number of backups when saved
+func TestSaveBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := NewProject(filepath.Join(dir, "data"), []Record{testRecord("A", 1, "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Backups = 2
+
+	filename := filepath.Join(dir, "project.json")
+	var saved []string
+	for _, notes := range []string{"first", "second", "third"} {
+		p.Entries[0].Notes = notes
+		if err = p.Save(filename); err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, readTestFile(t, filename))
+	}
+
+	if backup := readTestFile(t, projectBackupName(filename, 1)); backup != saved[1] {
+		t.Error("Most recent backup is not the second save")
+	}
+
+	if backup := readTestFile(t, projectBackupName(filename, 2)); backup != saved[0] {
+		t.Error("Oldest backup is not the first save")
+	}
+
+	if _, err = os.Stat(projectBackupName(filename, 3)); err == nil {
+		t.Error("Too many backups were kept")
+	}
+
+	if loaded, err := LoadProject(filename, false); err != nil {
+		t.Fatal(err)
+	} else if loaded.Entries[0].Notes != "third" {
+		t.Errorf("Loaded notes \"%s\"", loaded.Entries[0].Notes)
+	}
+}
+
+// Attempt to lock a project's lock file independently of LockProject
+func tryLock(t *testing.T, filename string, exclusive bool) error {
+	f, err := os.Open(projectLockName(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	return lockFile(f, exclusive)
+}
+
+func TestLockProject(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Project locking is not supported on Windows")
+	}
+
+	var lockTests = []struct {
+		name      string
+		held      bool // Lock held by the first LockProject() call
+		exclusive bool // Whether the second lock is exclusive
+		expectErr bool // Second lock is expected to fail
+	}{
+		{"exclusive/exclusive", true, true, true},
+		{"exclusive/shared", true, false, true},
+		{"shared/exclusive", false, true, true},
+		{"shared/shared", false, false, false},
+	}
+
+	for _, test := range lockTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			filename := filepath.Join(dir, "project.json")
+
+			lock, err := LockProject(filename, test.held)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lock.Unlock()
+
+			_, err = LockProject(filename, test.exclusive)
+			if test.expectErr && err == nil {
+				t.Error("Second lock succeeded")
+			} else if !test.expectErr && err != nil {
+				t.Errorf("Second lock failed: %s", err)
+			}
+		})
+	}
+}
+
+// Discarded locks are held until they are explicitly released
+func TestLockProjectDiscarded(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Project locking is not supported on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "project.json")
+	if _, err = LockProject(filename, true); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.GC()
+	runtime.GC()
+
+	if err = tryLock(t, filename, true); err != errLocked {
+		t.Fatalf("Expected %v after GC, got %v", errLocked, err)
+	}
+
+	// Release it, via the only remaining reference
+	heldLocks.Lock()
+	var lock *ProjectLock
+	for l := range heldLocks.locks {
+		if l.file != nil && l.file.Name() == projectLockName(filename) {
+			lock = l
+		}
+	}
+	heldLocks.Unlock()
+
+	if lock == nil {
+		t.Fatal("Lock is not referenced")
+	} else if err = lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tryLock(t, filename, true); err != nil {
+		t.Errorf("Lock was not released: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Project file schema versions and migrations
+ *
+ * Project files are stamped with the version of their schema. Files written
+ * with an older schema are upgraded when they are loaded, by applying each
+ * of the registered migrations in turn to the file's (untyped) JSON content,
+ * prior to decoding it into a Project.
+ */
+
+package reid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Version of the project file schema written by this version of reid.
+// Increment this, and register a migration below, whenever Project or
+// ProjectEntry changes in a way that would cause older files to be misread.
+const ProjectSchemaVersion = 2
+
+// The untyped JSON content of a project file
+type projectDoc map[string]interface{}
+
+type projectMigration struct {
+	Description string
+	Migrate     func(doc projectDoc) error
+}
+
+// projectMigrations[i] upgrades a project file from schema version i to i+1.
+// Version 0 denotes files written prior to the introduction of SchemaVersion.
+var projectMigrations = []projectMigration{
+	{"Identify entries by record number or DOI", migrateToRecordIdentity},
+	{"Store paths relative to the data and library roots", migrateToRoots},
+}
+
+func init() {
+	if len(projectMigrations) != ProjectSchemaVersion {
+		panic("A migration must be registered for each project schema version")
+	}
+}
+
+// Re-encode an untyped JSON value into the specified type
+func remarshal(value interface{}, v interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (doc projectDoc) entries() ([]map[string]interface{}, error) {
+	var entries []map[string]interface{}
+
+	items, ok := doc["Entries"].([]interface{})
+	if !ok {
+		if doc["Entries"] == nil {
+			return entries, nil
+		}
+		return entries, fmt.Errorf("Invalid project file: Entries is not a list")
+	}
+
+	for i, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return entries, fmt.Errorf("Invalid project file: Entry %d is not an object", i)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (doc projectDoc) schemaVersion() (int, error) {
+	value, exists := doc["SchemaVersion"]
+	if !exists {
+		return 0, nil
+	}
+
+	if n, ok := value.(json.Number); ok {
+		if version, err := n.Int64(); err == nil && version >= 0 {
+			return int(version), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid project file schema version: %v", value)
+}
+
+/*
+ * 0 -> 1: Entries were identified by their metadata hash. Assign them their
+ * record number or DOI-based identifiers, retaining the metadata hash in
+ * PrevHashes so that it may still be used to select the entry.
+ */
+func migrateToRecordIdentity(doc projectDoc) error {
+	entries, err := doc.entries()
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		var e ProjectEntry
+
+		if err := remarshal(entry["Record"], &e.Record); err != nil {
+			return err
+		}
+
+		e.Hash, _ = entry["Hash"].(string)
+		e.setIdentity(func(id string) bool { return used[id] })
+		used[e.Hash] = true
+
+		entry["Hash"] = e.Hash
+		entry["IDScheme"] = e.IDScheme
+		entry["PrevHashes"] = e.PrevHashes
+	}
+
+	return nil
+}
+
+/*
+ * 1 -> 2: All paths were absolute. Set the library root to the directory
+ * containing all of the project's PDFs. Paths within the roots are made
+ * relative when the upgraded project is saved.
+ */
+func migrateToRoots(doc projectDoc) error {
+	entries, err := doc.entries()
+	if err != nil {
+		return err
+	}
+
+	records := make([]Record, len(entries))
+	for i, entry := range entries {
+		if err := remarshal(entry["Record"], &records[i]); err != nil {
+			return err
+		}
+	}
+
+	doc["LibraryRoot"] = libraryRootOf(records)
+	return nil
+}
+
+// Write a copy of a project file's original contents prior to migrating it.
+// Existing backups are not overwritten.
+func backupProjectFile(filename string, data []byte, version int) (string, error) {
+	backup := fmt.Sprintf("%s.v%d.bak", filename, version)
+
+	if _, err := os.Stat(backup); err == nil {
+		Verbosef("Not overwriting existing backup: %s\n", backup)
+		return backup, nil
+	}
+
+	return backup, ioutil.WriteFile(backup, data, 0640)
+}
+
+/*
+ * Decode a project file's contents, upgrading them to the current schema
+ * version if necessary. Returns the (possibly upgraded) contents, and the
+ * version of the schema they were written with. The file itself is neither
+ * modified nor backed up (see Project.Save).
+ *
+ * An error is returned for files written by a newer version of reid.
+ */
+func upgradeProjectFile(filename string, data []byte) ([]byte, int, error) {
+	doc := make(projectDoc)
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, 0, err
+	}
+
+	version, err := doc.schemaVersion()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if version > ProjectSchemaVersion {
+		return nil, version, fmt.Errorf("%s was written by a newer version of reid "+
+			"(project schema version %d). This version (%s) supports project "+
+			"schema versions up to %d. Please upgrade reid.",
+			filename, version, Version.String(), ProjectSchemaVersion)
+	}
+
+	if version == ProjectSchemaVersion {
+		return data, version, nil
+	}
+
+	Verbosef("Upgrading project file %s from schema version %d to %d\n",
+		filename, version, ProjectSchemaVersion)
+
+	for v := version; v < ProjectSchemaVersion; v++ {
+		Verbosef("Applying project migration %d -> %d: %s\n", v, v+1, projectMigrations[v].Description)
+		if err := projectMigrations[v].Migrate(doc); err != nil {
+			return nil, version, fmt.Errorf("Failed to upgrade %s to schema version %d: %s", filename, v+1, err)
+		}
+	}
+
+	doc["SchemaVersion"] = ProjectSchemaVersion
+	data, err = json.Marshal(doc)
+	return data, version, err
+}
@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Tests of project file schema migrations, using the project files in
+ * testdata/ written with each prior schema version
+ */
+
+package reid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Expected identity of an upgraded entry
+type wantIdentity struct {
+	Hash       string
+	IDScheme   string
+	PrevHashes []string
+}
+
+var upgradeTests = []struct {
+	fixture     string
+	version     int  // Schema version the fixture was written with
+	expectError bool // Upgrade is expected to fail
+	libraryRoot string
+	identities  []wantIdentity
+}{
+	{
+		fixture:     "project_v0.json",
+		version:     0,
+		libraryRoot: "/home/user/Library.Data/PDF",
+		identities: []wantIdentity{
+			// Record number
+			{"9eb262a428a351a4496481dfd96aeb41", IDSchemeRecNumber,
+				[]string{"6616609b8a38c4710bb7d592562043d2"}},
+
+			// DOI
+			{identityHash(IDSchemeDOI, "10.1000/book").String(), IDSchemeDOI,
+				[]string{"86ce210295520bd3f595dac6db8de47f"}},
+
+			// DOI shared with the previous entry: keeps its metadata hash
+			{"f5082a97d514da499bf75fa7a717ca01", IDSchemeHash, nil},
+
+			// Neither: keeps its metadata hash
+			{"e62ed2a93ed52f416b0b437123f813a3", IDSchemeHash, nil},
+		},
+	},
+	{
+		fixture:     "project_v1.json",
+		version:     1,
+		libraryRoot: "/home/user/Library.Data/PDF",
+		identities: []wantIdentity{
+			{"9eb262a428a351a4496481dfd96aeb41", IDSchemeRecNumber,
+				[]string{"6616609b8a38c4710bb7d592562043d2"}},
+			{"e62ed2a93ed52f416b0b437123f813a3", IDSchemeHash, nil},
+		},
+	},
+	{
+		fixture:     "project_v99.json",
+		version:     99,
+		expectError: true,
+	},
+	{
+		fixture:     "project_bad_version.json",
+		expectError: true,
+	},
+}
+
+// Copy a fixture into a temporary directory, as backups are written
+// alongside it. Returns the copy's filename and contents.
+func copyFixture(t *testing.T, dir, fixture string) (string, []byte) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, fixture)
+	if err = ioutil.WriteFile(filename, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename, data
+}
+
+func TestUpgradeProjectFile(t *testing.T) {
+	for _, test := range upgradeTests {
+		t.Run(test.fixture, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			filename, original := copyFixture(t, dir, test.fixture)
+
+			data, version, err := upgradeProjectFile(filename, original)
+			if test.expectError {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if version != test.version {
+				t.Errorf("Version: got %d, expected %d", version, test.version)
+			}
+
+			var p Project
+			if err = json.Unmarshal(data, &p); err != nil {
+				t.Fatal(err)
+			}
+
+			if p.SchemaVersion != ProjectSchemaVersion {
+				t.Errorf("SchemaVersion: got %d, expected %d", p.SchemaVersion, ProjectSchemaVersion)
+			}
+
+			if p.LibraryRoot != test.libraryRoot {
+				t.Errorf("LibraryRoot: got %s, expected %s", p.LibraryRoot, test.libraryRoot)
+			}
+
+			if len(p.Entries) != len(test.identities) {
+				t.Fatalf("Got %d entries, expected %d", len(p.Entries), len(test.identities))
+			}
+
+			for i, want := range test.identities {
+				e := &p.Entries[i]
+				got := wantIdentity{e.Hash, e.IDScheme, e.PrevHashes}
+				if len(got.PrevHashes) == 0 {
+					got.PrevHashes = nil
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Entry %d: got %+v, expected %+v", i, got, want)
+				}
+			}
+
+			// Backups are written only when the project is saved
+			if matches, _ := filepath.Glob(filename + ".v*.bak"); len(matches) != 0 {
+				t.Errorf("Unexpected backups: %v", matches)
+			}
+
+			// Upgraded contents must be left as-is
+			again, version, err := upgradeProjectFile(filename, data)
+			if err != nil {
+				t.Fatal(err)
+			} else if version != ProjectSchemaVersion || !bytes.Equal(again, data) {
+				t.Error("Current project file was modified")
+			}
+		})
+	}
+}
+
+// Copy a fixture into a temporary directory, using it as the data directory,
+// such that the project may be loaded
+func loadableFixture(t *testing.T, dir, fixture string) (string, []byte) {
+	filename, data := copyFixture(t, dir, fixture)
+	data = bytes.Replace(data, []byte("/home/user/reid-data"), []byte(dir), -1)
+
+	if err := ioutil.WriteFile(filename, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename, data
+}
+
+// Upgraded projects are saved, after backing up the original, only when
+// loaded for exclusive use
+func TestLoadProjectUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename, original := loadableFixture(t, dir, "project_v0.json")
+	backup := filename + ".v0.bak"
+
+	p, err := LoadProject(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if p.Entries[0].IDScheme != IDSchemeRecNumber {
+		t.Error("Project was not upgraded in memory")
+	}
+
+	if data, _ := ioutil.ReadFile(filename); !bytes.Equal(data, original) {
+		t.Error("Project was saved by a read-only load")
+	}
+
+	if _, err = os.Stat(backup); err == nil {
+		t.Error("Project was backed up by a read-only load")
+	}
+
+	if _, err = LoadProject(filename, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := ioutil.ReadFile(backup); err != nil {
+		t.Fatalf("Backup not written: %s", err)
+	} else if !bytes.Equal(data, original) {
+		t.Error("Backup differs from the original")
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, version, err := upgradeProjectFile(filename, data); err != nil || version != ProjectSchemaVersion {
+		t.Errorf("Upgraded project was not saved (version %d, %v)", version, err)
+	}
+}
+
+// Existing backups are not overwritten by subsequent upgrades
+func TestLoadProjectKeepsBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename, _ := loadableFixture(t, dir, "project_v1.json")
+	backup := filename + ".v1.bak"
+	if err = ioutil.WriteFile(backup, []byte("earlier"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = LoadProject(filename, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := ioutil.ReadFile(backup); err != nil || string(data) != "earlier" {
+		t.Errorf("Existing backup was overwritten (%v)", err)
+	}
+}
+
+// Files written with the current schema version are not backed up
+func TestUpgradeProjectFileCurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "project.json")
+	data := []byte(fmt.Sprintf(`{"SchemaVersion": %d, "DataDir": "data", "Entries": []}`,
+		ProjectSchemaVersion))
+
+	upgraded, version, err := upgradeProjectFile(filename, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != ProjectSchemaVersion || !bytes.Equal(upgraded, data) {
+		t.Error("Current project file was modified")
+	}
+
+	if matches, _ := filepath.Glob(filename + ".v*.bak"); len(matches) != 0 {
+		t.Errorf("Unexpected backups: %v", matches)
+	}
+}
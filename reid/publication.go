@@ -0,0 +1,262 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Publication (venue) aliases
+ *
+ * The same publication is frequently listed under both its full title and
+ * one or more abbreviations (e.g., "IEEE Transactions on Information Theory"
+ * and "IEEE Trans. Inf. Theory"). Titles are grouped using the alternate
+ * titles found in library records (see Record.AltPublications), along with an
+ * optional user-provided alias file, and each group is assigned a canonical
+ * name.
+ */
+
+package reid
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// User-provided mapping of publication title variants to canonical titles,
+// keyed on the reduced variant (see Reduce)
+type PublicationAliases map[string]string
+
+// Load a publication alias file. See loadAliasFile() for its format.
+func LoadPublicationAliases(filename string) (PublicationAliases, error) {
+	aliases, err := loadAliasFile(filename, Reduce)
+	return PublicationAliases(aliases), err
+}
+
+type PublicationIndex struct {
+	parent map[string]string         // Union-find forest, keyed on reduced title
+	forms  map[string]map[string]int // Titles (and # of uses), by reduced title
+	pinned map[string]string         // Canonical titles from aliases, by reduced title
+
+	canonical map[string]string // Canonical title, by root reduced title
+}
+
+func (idx *PublicationIndex) find(key string) string {
+	for idx.parent[key] != key {
+		idx.parent[key] = idx.parent[idx.parent[key]]
+		key = idx.parent[key]
+	}
+	return key
+}
+
+// Add a title to the index, returning its key (or "" if it reduces to nothing)
+func (idx *PublicationIndex) add(title string, count int) string {
+	key := Reduce(title)
+	if len(key) == 0 {
+		return ""
+	}
+
+	if _, exists := idx.parent[key]; !exists {
+		idx.parent[key] = key
+		idx.forms[key] = make(map[string]int)
+	}
+
+	if count != 0 {
+		idx.forms[key][strings.TrimSpace(title)] += count
+	}
+
+	return key
+}
+
+func (idx *PublicationIndex) union(a, b string) {
+	if len(a) == 0 || len(b) == 0 {
+		return
+	}
+
+	if ra, rb := idx.find(a), idx.find(b); ra != rb {
+		idx.parent[rb] = ra
+	}
+}
+
+/*
+ * Group the publication titles used by the provided records, using their
+ * alternate titles and any user-provided aliases.
+ *
+ * The canonical title of each group is the one specified in the alias file,
+ * if any. Otherwise, the longest title is used, as abbreviations are
+ * (necessarily) shorter than the titles they abbreviate.
+ */
+func NewPublicationIndex(records []Record, aliases PublicationAliases) *PublicationIndex {
+	idx := &PublicationIndex{
+		parent:    make(map[string]string),
+		forms:     make(map[string]map[string]int),
+		pinned:    make(map[string]string),
+		canonical: make(map[string]string),
+	}
+
+	for i := range records {
+		pub := idx.add(records[i].Publication, 1)
+		for _, alt := range records[i].AltPublications {
+			idx.union(pub, idx.add(alt, 1))
+		}
+	}
+
+	for variant, canonical := range aliases {
+		key := idx.add(canonical, 0)
+		idx.pinned[key] = canonical
+
+		if _, exists := idx.parent[variant]; !exists {
+			idx.parent[variant] = variant
+			idx.forms[variant] = make(map[string]int)
+		}
+		idx.union(key, variant)
+	}
+
+	// Select canonical titles, preferring those specified via aliases
+	for key, canonical := range idx.pinned {
+		root := idx.find(key)
+		if current, have := idx.canonical[root]; !have || canonical < current {
+			idx.canonical[root] = canonical
+		}
+	}
+
+	counts := make(map[string]int) // Uses of the current canonical title, by root
+	for key, forms := range idx.forms {
+		root := idx.find(key)
+		if _, pinned := idx.pinned[Reduce(idx.canonical[root])]; pinned {
+			continue
+		}
+
+		for form, count := range forms {
+			current := idx.canonical[root]
+			if len(form) > len(current) ||
+				(len(form) == len(current) && count > counts[root]) ||
+				(len(form) == len(current) && count == counts[root] && form < current) {
+				idx.canonical[root] = form
+				counts[root] = count
+			}
+		}
+	}
+
+	return idx
+}
+
+// Returns the canonical title of the publication, or `title` itself if it is
+// not known to the index
+func (idx *PublicationIndex) Canonical(title string) string {
+	key := Reduce(title)
+	if _, exists := idx.parent[key]; !exists {
+		return title
+	}
+
+	if canonical := idx.canonical[idx.find(key)]; len(canonical) != 0 {
+		return canonical
+	}
+	return title
+}
+
+// Returns the canonical titles of the publications matching a user-specified
+// title. This may be any title known to the index, or an abbreviation of one
+// (e.g., "IEEE Trans. Inf. Theory"), in which case more than one publication
+// may match.
+func (idx *PublicationIndex) Match(query string) []string {
+	key := Reduce(query)
+	if _, exists := idx.parent[key]; exists {
+		return []string{idx.Canonical(query)}
+	}
+
+	matches := NewStringSet(10)
+	for _, forms := range idx.forms {
+		for form := range forms {
+			if abbreviates(query, form) {
+				matches.Insert(idx.Canonical(form))
+			}
+		}
+	}
+
+	sort.Strings(matches.Values)
+	return matches.Values
+}
+
+var reWords = regexp.MustCompile(`[\pL\pN]+`)
+
+// Words omitted from abbreviated publication titles
+var titleStopWords = map[string]bool{
+	"of": true, "on": true, "the": true, "and": true, "for": true,
+	"in": true, "de": true, "der": true, "und": true,
+}
+
+// Returns true if `abbr` is an abbreviation of `title`. Each word of the
+// abbreviation must be a prefix of the corresponding word of the title,
+// ignoring stop words. (e.g., "IEEE Trans. Inf. Theory")
+func abbreviates(abbr, title string) bool {
+	var abbrWords, titleWords []string
+
+	for _, w := range reWords.FindAllString(strings.ToLower(abbr), -1) {
+		if !titleStopWords[w] {
+			abbrWords = append(abbrWords, w)
+		}
+	}
+
+	for _, w := range reWords.FindAllString(strings.ToLower(title), -1) {
+		if !titleStopWords[w] {
+			titleWords = append(titleWords, w)
+		}
+	}
+
+	if len(abbrWords) == 0 || len(abbrWords) != len(titleWords) {
+		return false
+	}
+
+	for i, w := range abbrWords {
+		if !strings.HasPrefix(titleWords[i], w) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A publication, and the titles it appears under
+type PublicationGroup struct {
+	Name     string   // Canonical title
+	Variants []string // Titles, as they appear in records
+}
+
+// Returns all publications, sorted by canonical title
+func (idx *PublicationIndex) Groups() []PublicationGroup {
+	var groups []PublicationGroup
+	byRoot := make(map[string]int)
+
+	var keys []string
+	for key := range idx.forms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		root := idx.find(key)
+		i, exists := byRoot[root]
+		if !exists {
+			i = len(groups)
+			byRoot[root] = i
+			groups = append(groups, PublicationGroup{Name: idx.canonical[root]})
+		}
+
+		for form := range idx.forms[key] {
+			groups[i].Variants = append(groups[i].Variants, form)
+		}
+	}
+
+	for i := range groups {
+		sort.Strings(groups[i].Variants)
+	}
+	sort.Sort(byGroupName(groups))
+
+	return groups
+}
+
+type byGroupName []PublicationGroup
+
+func (g byGroupName) Len() int      { return len(g) }
+func (g byGroupName) Swap(i, j int) { g[i], g[j] = g[j], g[i] }
+func (g byGroupName) Less(i, j int) bool {
+	return strings.ToLower(g[i].Name) < strings.ToLower(g[j].Name)
+}
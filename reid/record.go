@@ -21,16 +21,48 @@ import (
  */
 type Record struct {
 	PDFs []string // Path to one or more PDFs
+	URLs []string // Non-local (e.g., http://) links associated with the record
 
 	Title       string // Record title (e.g., article name)
 	Publication string // Publication title (e.g., journal name)
 	Year        int    // Publication year
 
+	AltPublications []string // Alternate publication titles (e.g., abbreviations)
+
 	Authors []string // List of authors
 
 	Language string // Language the text is in
+
+	DOI      string   // Digital Object Identifier (e.g., 10.1000/xyz123)
+	Abstract string   // Abstract text
+	Keywords []string // List of keywords
+	Volume   string   // Volume of the publication
+	Issue    string   // Issue (number) of the publication
+	Pages    string   // Page range (e.g., 123-145)
+
+	RefType       string // Reference type (e.g., "Journal Article")
+	RecNumber     int    // Library-specific record number (EndNote rec-number, Zotero itemID)
+	AccessionNum  string // Accession number
+	Label         string // User-defined label
+	ResearchNotes string // User's research notes
+
+	// If the record was loaded despite being incomplete, this lists what it
+	// lacks (e.g., StatusNoFullText). Such records are not converted or
+	// searched. This is empty for complete records.
+	Status []string
 }
 
+// Reasons a record may be incomplete
+const (
+	StatusNoFullText         = "no full text"
+	StatusMissingPublication = "missing publication"
+	StatusMissingYear        = "missing year"
+	StatusMissingAuthors     = "missing authors"
+
+	// The entry's record is no longer in the library (see Project.Sync)
+	StatusRemoved = "removed from library"
+)
+
 // If record is complete (at load-time), returns: true, ""
 // Otherwise, returns: false, <Name of first incomplete field>
 func (r *Record) isComplete() (bool, string) {
@@ -64,6 +96,88 @@ func (r *Record) isComplete() (bool, string) {
 	return true, ""
 }
 
+// Consider `name` as the record's publication title. Libraries have been
+// observed to use the fields holding publication titles and abbreviations
+// inconsistently, so the longest is assumed to be the full title. The others
+// are retained as alternate titles.
+func (r *Record) addPublication(name string) {
+	name = strings.TrimSpace(name)
+	if len(name) <= len(r.Publication) {
+		r.addAltPublication(name)
+		return
+	}
+
+	prev := r.Publication
+	r.Publication = name
+
+	// The new title may have previously been listed as an alternate
+	reduced := Reduce(name)
+	for i, alt := range r.AltPublications {
+		if Reduce(alt) == reduced {
+			r.AltPublications = append(r.AltPublications[:i], r.AltPublications[i+1:]...)
+			break
+		}
+	}
+
+	r.addAltPublication(prev)
+}
+
+// Retain `name` as an alternate title of the record's publication
+func (r *Record) addAltPublication(name string) {
+	name = strings.TrimSpace(name)
+	reduced := Reduce(name)
+	if len(reduced) == 0 || reduced == Reduce(r.Publication) {
+		return
+	}
+
+	for _, alt := range r.AltPublications {
+		if Reduce(alt) == reduced {
+			return
+		}
+	}
+
+	r.AltPublications = append(r.AltPublications, name)
+}
+
+// Returns the Status entries applicable to a record that has a title, but is
+// otherwise incomplete
+func (r *Record) incompleteStatus() []string {
+	var status []string
+
+	if len(r.PDFs) == 0 {
+		status = append(status, StatusNoFullText)
+	}
+
+	if len(r.Publication) == 0 {
+		status = append(status, StatusMissingPublication)
+	}
+
+	if r.Year <= 0 {
+		status = append(status, StatusMissingYear)
+	}
+
+	if len(r.Authors) == 0 {
+		status = append(status, StatusMissingAuthors)
+	}
+
+	return status
+}
+
+// Returns true if the record was loaded despite being incomplete
+func (r *Record) IsIncomplete() bool {
+	return len(r.Status) != 0
+}
+
+// Returns true if the record has been removed from its library
+func (r *Record) isRemoved() bool {
+	for _, status := range r.Status {
+		if status == StatusRemoved {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Record) String() string {
 	return fmt.Sprintf("\"%s\" %s (%s %d)", r.Title, r.Authors, r.Publication, r.Year)
 }
@@ -83,7 +197,9 @@ func (r *Record) computeHash() []byte {
 	h := md5.New()
 	h.Write([]byte(title))
 	h.Write([]byte{fieldSep})
-	h.Write([]byte{pub[0]})
+	if len(pub) != 0 {
+		h.Write([]byte{pub[0]}) // Incomplete records may lack a publication
+	}
 	h.Write([]byte{fieldSep})
 	h.Write(year)
 	h.Write([]byte{fieldSep})
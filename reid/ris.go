@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * RIS "parsing". Like the EndNote XML support, this only scrapes the tags
+ * we're particularly interested in.
+ */
+package reid
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Tag lines take the form "XX  - value". Some exporters aren't particularly
+// careful about the whitespace, so we're a bit lenient here.
+var reRISLine = regexp.MustCompile(`^([A-Z][A-Z0-9]) {1,2}-(?: (.*))?$`)
+
+var reYear = regexp.MustCompile(`[0-9]{4}`)
+
+// Maximum line length we're willing to handle (e.g., for long abstracts)
+const risMaxLineLen = 1024 * 1024
+
+type risLoader struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	baseDir string // Relative file links are resolved from here
+	lineNum int
+	pathMap PathMap
+
+	keepIncomplete bool
+}
+
+func newRISLoader(filename string) (*risLoader, error) {
+	var l *risLoader = new(risLoader)
+	var err error
+
+	l.file, err = os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	l.scanner = bufio.NewScanner(l.file)
+	l.scanner.Buffer(make([]byte, 0, 64*1024), risMaxLineLen)
+
+	if l.baseDir, err = filepath.Abs(filepath.Dir(filename)); err != nil {
+		l.file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Returns the next tag and its value. `ok` is false once the end of the
+// file has been reached.
+func (l *risLoader) nextTag() (tag, value string, ok bool, err error) {
+	for l.scanner.Scan() {
+		line := l.scanner.Text()
+		l.lineNum++
+
+		if l.lineNum == 1 {
+			line = strings.TrimPrefix(line, "\ufeff") // UTF-8 BOM
+		}
+
+		line = strings.TrimRight(line, "\r ")
+		if len(line) == 0 {
+			continue
+		}
+
+		m := reRISLine.FindStringSubmatch(line)
+		if m == nil {
+			Verbosef("Ignoring malformed RIS line %d: %s\n", l.lineNum, line)
+			continue
+		}
+
+		return m[1], strings.TrimSpace(m[2]), true, nil
+	}
+
+	return "", "", false, l.scanner.Err()
+}
+
+// Load tags until the "ER" (end of reference) tag is reached.
+// Returns a nil Record if the record is incomplete.
+func (l *risLoader) loadRecord(refType string) (*Record, error) {
+	var rec Record = Record{RefType: refType}
+
+	Verbose("Processing record")
+
+	for {
+		tag, value, ok, err := l.nextTag()
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			Debug("Reached end of file before end of record")
+			return nil, nil
+		}
+
+		switch tag {
+		case "TI", "T1":
+			if len(rec.Title) == 0 {
+				rec.Title = value
+			}
+
+		// As with the XML, assume the longest of these is the full publication title
+		case "T2", "JO", "JF", "JA", "J1", "J2":
+			rec.addPublication(value)
+
+		case "PY", "Y1", "DA":
+			if rec.Year == 0 {
+				if year := reYear.FindString(value); len(year) != 0 {
+					rec.Year, _ = strconv.Atoi(year)
+				} else {
+					Debugf("Invalid date on line %d: %s\n", l.lineNum, value)
+				}
+			}
+
+		case "AU", "A1":
+			rec.Authors = append(rec.Authors, value)
+
+		case "LA":
+			rec.Language = value
+
+		case "DO":
+			rec.DOI = normalizeDOI(value)
+
+		case "AB", "N2":
+			if len(rec.Abstract) == 0 {
+				rec.Abstract = value
+			}
+
+		case "KW":
+			rec.Keywords = append(rec.Keywords, value)
+
+		case "VL":
+			rec.Volume = value
+
+		case "IS":
+			rec.Issue = value
+
+		case "SP":
+			rec.Pages = value + rec.Pages
+
+		case "EP":
+			rec.Pages += "-" + value
+
+		case "AN":
+			rec.AccessionNum = value
+
+		case "LB":
+			rec.Label = value
+
+		case "RN":
+			rec.ResearchNotes = value
+
+		case "L1":
+			if pdf, local := localFilePath(value, l.baseDir, l.pathMap); local {
+				rec.PDFs = append(rec.PDFs, pdf)
+			} else {
+				rec.URLs = append(rec.URLs, value)
+			}
+
+		case "UR":
+			// Only local PDFs are of use to us here; keep track of the rest
+			if pdf, local := localFilePath(value, l.baseDir, l.pathMap); !local {
+				rec.URLs = append(rec.URLs, value)
+			} else if isPDF(pdf) {
+				rec.PDFs = append(rec.PDFs, pdf)
+			}
+
+		case "ER":
+			if accept, _ := acceptRecord(&rec, l.keepIncomplete); !accept {
+				return nil, nil
+			}
+			return &rec, nil
+
+		default:
+			Verbosef("Ignoring tag while processing record: %s\n", tag)
+		}
+	}
+}
+
+func LoadRecordsFromRIS(filename string, config LoadConfig) ([]Record, error) {
+	var records RecordSet = NewRecordSet(recSetCapacity)
+
+	l, err := newRISLoader(filename)
+	if err != nil {
+		return []Record{}, err
+	}
+	defer l.file.Close()
+	l.pathMap = config.PathMap
+	l.keepIncomplete = config.KeepIncomplete
+
+	lowerLangs(config.Languages)
+
+	for {
+		// Records begin with a "TY" (type of reference) tag
+		tag, refType, ok, err := l.nextTag()
+		if err != nil {
+			return []Record{}, err
+		} else if !ok {
+			return records.Values, nil
+		} else if tag != "TY" {
+			Verbosef("Ignoring tag outside of record on line %d: %s\n", l.lineNum, tag)
+			continue
+		}
+
+		rec, err := l.loadRecord(refType)
+		if err != nil {
+			return []Record{}, err
+		} else if rec != nil {
+			insertRecord(&records, rec, config.Languages)
+		}
+	}
+}
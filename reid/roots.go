@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Project roots
+ *
+ * So that projects may be moved between machines (or mount points), paths are
+ * stored in project files relative to one of two roots:
+ *	- The data root (DataDir), containing minified text files
+ *	- The library root (LibraryRoot), containing the library's PDFs
+ *
+ * The roots themselves are stored relative to the directory containing the
+ * project file, when they reside within it. Paths outside of these roots are
+ * stored as-is. Within a loaded Project, all paths are absolute.
+ */
+
+package reid
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns `path` relative to `root`, if it lies within it
+func relativeTo(root, path string) (string, bool) {
+	if len(root) == 0 || !filepath.IsAbs(path) {
+		return path, false
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path, false
+	}
+
+	return rel, true
+}
+
+// Returns `path` joined to `root`, if it is a relative (local) path
+func resolveAgainst(root, path string) string {
+	if len(root) == 0 || len(path) == 0 || filepath.IsAbs(path) || isWindowsPath(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// Returns whether `dir` is the root of the filesystem (or of a volume)
+func isRootDir(dir string) bool {
+	return filepath.Dir(dir) == dir
+}
+
+// Returns the deepest directory containing all of the provided absolute
+// paths, or an empty string if there are none, or they have no common
+// directory other than the root of the filesystem (or of a volume). Other
+// paths are ignored.
+func commonDir(paths []string) string {
+	var dir string
+
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			continue
+		}
+
+		if len(dir) == 0 {
+			dir = filepath.Dir(path)
+		}
+
+		for {
+			if isRootDir(dir) {
+				return ""
+			} else if _, within := relativeTo(dir, path); within {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	return dir
+}
+
+// Returns the library root that contains all of the records' PDFs
+func libraryRootOf(records []Record) string {
+	var pdfs []string
+	for i := range records {
+		pdfs = append(pdfs, records[i].PDFs...)
+	}
+	return commonDir(pdfs)
+}
+
+// Directory against which a project file's roots are resolved
+func projectBaseDir(filename string) (string, error) {
+	return filepath.Abs(filepath.Dir(filename))
+}
+
+// Convert the (possibly relative) paths read from a project file into
+// absolute paths
+func (p *Project) resolvePaths(filename string) error {
+	base, err := projectBaseDir(filename)
+	if err != nil {
+		return err
+	}
+
+	p.DataDir = resolveAgainst(base, p.DataDir)
+	p.LibraryRoot = resolveAgainst(base, p.LibraryRoot)
+
+	for i := range p.Entries {
+		e := &p.Entries[i]
+		for j := range e.MiniFiles {
+			e.MiniFiles[j] = resolveAgainst(p.DataDir, e.MiniFiles[j])
+		}
+		for j := range e.Record.PDFs {
+			e.Record.PDFs[j] = resolveAgainst(p.LibraryRoot, e.Record.PDFs[j])
+		}
+	}
+
+	return nil
+}
+
+// Returns a copy of the project, with paths relative to its roots, suitable
+// for writing to `filename`
+func (p *Project) portableCopy(filename string) (*Project, error) {
+	base, err := projectBaseDir(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c := *p
+	c.DataDir, _ = relativeTo(base, p.DataDir)
+	c.LibraryRoot, _ = relativeTo(base, p.LibraryRoot)
+
+	c.Entries = make([]ProjectEntry, len(p.Entries))
+	for i, e := range p.Entries {
+		e.MiniFiles = make([]string, len(e.MiniFiles))
+		for j, f := range p.Entries[i].MiniFiles {
+			e.MiniFiles[j], _ = relativeTo(p.DataDir, f)
+		}
+
+		e.Record.PDFs = make([]string, len(e.Record.PDFs))
+		for j, pdf := range p.Entries[i].Record.PDFs {
+			e.Record.PDFs[j], _ = relativeTo(p.LibraryRoot, pdf)
+		}
+
+		c.Entries[i] = e
+	}
+
+	return &c, nil
+}
+
+// Replace the `from` prefix of a path with `to`
+func rebase(path, from, to string) string {
+	if rel, within := relativeTo(from, path); within {
+		return filepath.Join(to, rel)
+	}
+	return path
+}
+
+/*
+ * Re-point the project's data and/or library roots (e.g., after moving the
+ * project or library to another machine). Paths within the previous roots
+ * are moved to the new roots. An empty string leaves a root unchanged.
+ *
+ * The project is not saved, and its look-up tables are not updated.
+ */
+func (p *Project) SetRoots(dataDir, libraryRoot string) error {
+	var err error
+
+	if len(dataDir) != 0 {
+		if dataDir, err = filepath.Abs(dataDir); err != nil {
+			return err
+		}
+
+		for i := range p.Entries {
+			for j, f := range p.Entries[i].MiniFiles {
+				p.Entries[i].MiniFiles[j] = rebase(f, p.DataDir, dataDir)
+			}
+		}
+
+		Infof("Data root: %s -> %s\n", p.DataDir, dataDir)
+		p.DataDir = dataDir
+	}
+
+	if len(libraryRoot) != 0 {
+		if libraryRoot, err = filepath.Abs(libraryRoot); err != nil {
+			return err
+		}
+
+		for i := range p.Entries {
+			for j, pdf := range p.Entries[i].Record.PDFs {
+				p.Entries[i].Record.PDFs[j] = rebase(pdf, p.LibraryRoot, libraryRoot)
+			}
+		}
+
+		Infof("Library root: %s -> %s\n", p.LibraryRoot, libraryRoot)
+		p.LibraryRoot = libraryRoot
+	}
+
+	return nil
+}
+
+// The roots of a project, and how many of the files within them exist
+type ProjectRoots struct {
+	DataDir     string
+	LibraryRoot string
+
+	PDFs             int
+	MissingPDFs      int
+	MiniFiles        int
+	MissingMiniFiles int
+}
+
+func (p *Project) Roots() ProjectRoots {
+	r := ProjectRoots{DataDir: p.DataDir, LibraryRoot: p.LibraryRoot}
+
+	for _, e := range p.Entries {
+		for _, pdf := range e.Record.PDFs {
+			r.PDFs++
+			if _, err := os.Stat(pdf); err != nil {
+				if _, found := p.findPDF(pdf); !found {
+					r.MissingPDFs++
+				}
+			}
+		}
+
+		for _, f := range e.MiniFiles {
+			r.MiniFiles++
+			if _, err := os.Stat(f); err != nil {
+				r.MissingMiniFiles++
+			}
+		}
+	}
+
+	return r
+}
+
+func rootStatus(dir string) string {
+	if len(dir) == 0 {
+		return "(not set)"
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return dir + " (missing)"
+	}
+	return dir
+}
+
+func (r ProjectRoots) Pretty(eol string) string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "Data root:     %s%s", rootStatus(r.DataDir), eol)
+	fmt.Fprintf(&b, "Library root:  %s%s", rootStatus(r.LibraryRoot), eol)
+	fmt.Fprintf(&b, "PDFs:          %d (%d missing)%s", r.PDFs, r.MissingPDFs, eol)
+	fmt.Fprintf(&b, "Minified text: %d (%d missing)%s", r.MiniFiles, r.MissingMiniFiles, eol)
+
+	return b.String()
+}
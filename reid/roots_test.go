@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Tests of project root handling
+ */
+
+package reid
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+var commonDirTests = []struct {
+	name   string
+	paths  []string
+	expect string
+}{
+	{"none", nil, ""},
+	{"single", []string{"/lib/PDF/1/a.pdf"}, "/lib/PDF/1"},
+	{"siblings", []string{"/lib/PDF/1/a.pdf", "/lib/PDF/1/b.pdf"}, "/lib/PDF/1"},
+	{"cousins", []string{"/lib/PDF/1/a.pdf", "/lib/PDF/2/b.pdf"}, "/lib/PDF"},
+	{"nested", []string{"/lib/PDF/1/a.pdf", "/lib/PDF/2/x/b.pdf", "/lib/c.pdf"}, "/lib"},
+	{"relative ignored", []string{"1/a.pdf", "/lib/PDF/2/b.pdf"}, "/lib/PDF/2"},
+	{"only relative", []string{"1/a.pdf", "2/b.pdf"}, ""},
+	{"disjoint", []string{"/lib/PDF/1/a.pdf", "/mnt/scans/b.pdf"}, ""},
+	{"disjoint later", []string{"/lib/PDF/1/a.pdf", "/lib/PDF/2/b.pdf", "/mnt/c.pdf"}, ""},
+	{"in root", []string{"/a.pdf"}, ""},
+}
+
+func TestCommonDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test paths are Unix paths")
+	}
+
+	for _, test := range commonDirTests {
+		t.Run(test.name, func(t *testing.T) {
+			if dir := commonDir(test.paths); dir != filepath.FromSlash(test.expect) {
+				t.Errorf("Got \"%s\", expected \"%s\"", dir, test.expect)
+			}
+		})
+	}
+}
@@ -15,28 +15,56 @@ type searchFilter struct {
 	config *procSearchConfig
 
 	anyPublication bool
-	publications   map[string]bool
+	publications   map[string]bool // Keyed on reduced canonical title
+	pubIndex       *PublicationIndex
 
-	anyAuthor bool
-	authors   map[string]bool
+	anyAuthor   bool
+	authors     map[string]bool // Keyed on reduced canonical name
+	authorIndex *AuthorIndex
+
+	tags TagFilter
 }
 
-func (c *procSearchConfig) searchFilter() searchFilter {
+func (c *procSearchConfig) searchFilter(authors *AuthorIndex, pubs *PublicationIndex) searchFilter {
 	var f searchFilter
 
+	f.tags = c.tags
+
+	// Publications are matched on their canonical titles, such that
+	// abbreviated titles are matched
+	f.pubIndex = pubs
 	f.anyPublication = (len(c.publications) == 0)
 	if !f.anyPublication {
 		f.publications = make(map[string]bool, len(c.publications))
 		for _, p := range c.publications {
-			f.publications[p] = true
+			titles := pubs.Match(p)
+			if len(titles) == 0 {
+				Warnf("No publications matching: %s\n", p)
+			}
+
+			for _, title := range titles {
+				Debugf("Publication \"%s\" matched: %s\n", p, title)
+				f.publications[Reduce(title)] = true
+			}
 		}
 	}
 
+	// Authors are matched on their identity (i.e., canonical name),
+	// such that all variants of their names are matched
 	f.anyAuthor = (len(c.authors) == 0)
 	if !f.anyAuthor {
+		f.authorIndex = authors
 		f.authors = make(map[string]bool, len(c.authors))
 		for _, a := range c.authors {
-			f.authors[a] = true
+			clusters := authors.Match(a)
+			if len(clusters) == 0 {
+				Warnf("No authors matching: %s\n", a)
+			}
+
+			for _, cluster := range clusters {
+				Debugf("Author \"%s\" matched: %s\n", a, cluster.Name)
+				f.authors[Reduce(cluster.Name)] = true
+			}
 		}
 	}
 
@@ -44,15 +72,19 @@ func (c *procSearchConfig) searchFilter() searchFilter {
 }
 
 func (f *searchFilter) matches(e *ProjectEntry) bool {
+	if !f.tags.Matches(e) {
+		return false
+	}
+
 	if !f.anyPublication {
-		if !f.publications[Reduce(e.Record.Publication)] {
+		if !f.publications[Reduce(f.pubIndex.Canonical(e.Record.Publication))] {
 			return false
 		}
 	}
 
 	if !f.anyAuthor {
 		for _, author := range e.Record.Authors {
-			author := Reduce(author)
+			author := Reduce(f.authorIndex.Canonical(author))
 			if f.authors[author] {
 				return true
 			}
@@ -105,7 +137,7 @@ func (p *Project) Search(s SearchConfig) ([]SearchResult, error) {
 		return []SearchResult{}, err
 	}
 
-	filter := config.searchFilter()
+	filter := config.searchFilter(p.authorIndex, p.pubIndex)
 
 	if s.Start > s.End {
 		return []SearchResult{}, errors.New("Start year must be >= End year")
@@ -125,6 +157,10 @@ func (p *Project) Search(s SearchConfig) ([]SearchResult, error) {
 					return []SearchResult{}, err
 				}
 
+				for i := range r {
+					r[i].Record.Publication = p.pubIndex.Canonical(r[i].Record.Publication)
+				}
+
 				results = append(results, r...)
 			}
 		}
@@ -21,6 +21,8 @@ type SearchConfig struct {
 	Publications []string
 	Start        int
 	End          int
+	Tags         []string // Search only entries with any of these tags
+	ExcludeTags  []string // Skip entries with any of these tags
 }
 
 type query struct {
@@ -31,8 +33,9 @@ type query struct {
 // Processed search configuration
 type procSearchConfig struct {
 	queries      []query
-	authors      []string
-	publications []string
+	authors      []string // As specified; see AuthorIndex.Match()
+	publications []string // As specified; see PublicationIndex.Match()
+	tags         TagFilter
 }
 
 /* Process search configuration up front to avoid repeated
@@ -74,21 +77,26 @@ func (s *SearchConfig) process() (procSearchConfig, error) {
 
 	proc.authors = make([]string, len(s.Authors))
 	for i, author := range s.Authors {
-		a := Reduce(author)
-		if len(a) == 0 {
+		if len(Reduce(author)) == 0 {
 			return procSearchConfig{}, fmt.Errorf("Invalid author name: %s\n", author)
 		}
-		proc.authors[i] = a
+		proc.authors[i] = author
 	}
 
 	proc.publications = make([]string, len(s.Publications))
 	for i, pub := range s.Publications {
-		p := Reduce(pub)
-		if len(p) == 0 {
+		if len(Reduce(pub)) == 0 {
 			return procSearchConfig{}, fmt.Errorf("Invalid publication name: %s\n", pub)
 		}
-		proc.publications[i] = p
+		proc.publications[i] = pub
 	}
 
+	for _, tag := range append(s.Tags, s.ExcludeTags...) {
+		if err := validTag(tag); err != nil {
+			return procSearchConfig{}, err
+		}
+	}
+	proc.tags = TagFilter{Include: s.Tags, Exclude: s.ExcludeTags}
+
 	return proc, nil
 }
@@ -26,6 +26,7 @@ func (r SearchResult) Pretty(eol string) string {
 			"   Publication: %s\n"+
 			"   Author(s):   %s\n"+
 			"   Title:       %s\n"+
+			"   DOI:         %s\n"+
 			"%s%s",
 		r.Query,
 		r.Occurrences,
@@ -33,6 +34,7 @@ func (r SearchResult) Pretty(eol string) string {
 		r.Record.Publication,
 		strings.Join(r.Record.Authors, " / "),
 		r.Record.Title,
+		r.Record.DOI,
 		eol, eol)
 }
 
@@ -47,8 +49,12 @@ func SearchResultCSVHeader(sep, eol string) string {
 			"Year%s"+
 			"Publication%s"+
 			"Author(s)%s"+
-			"Title%s",
-		sep, sep, sep, sep, sep, eol)
+			"Title%s"+
+			"Volume%s"+
+			"Issue%s"+
+			"Pages%s"+
+			"DOI%s",
+		sep, sep, sep, sep, sep, sep, sep, sep, sep, eol)
 }
 
 func SearchResultCSVHeaderBytes(sep, eol string) []byte {
@@ -63,13 +69,21 @@ func (r SearchResult) CSV(sep, eol string) string {
 			`"%d"%s`+ // Year
 			`"%s"%s`+ // Publication
 			`"%s"%s`+ // Author(s)
-			`"%s"%s`, // Title
+			`"%s"%s`+ // Title
+			`"%s"%s`+ // Volume
+			`"%s"%s`+ // Issue
+			`"%s"%s`+ // Pages
+			`"%s"%s`, // DOI
 		r.Query, sep,
 		r.Occurrences, sep,
 		r.Record.Year, sep,
 		r.Record.Publication, sep,
 		authors, sep,
-		r.Record.Title, eol)
+		r.Record.Title, sep,
+		r.Record.Volume, sep,
+		r.Record.Issue, sep,
+		r.Record.Pages, sep,
+		r.Record.DOI, eol)
 }
 
 func (r SearchResult) CSVBytes(sep, eol string) []byte {
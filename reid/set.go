@@ -56,24 +56,32 @@ func (s *StringSet) CaseInsensitveInsert(val string) {
 
 type RecordSet struct {
 	Values []Record
-	has    map[RecordHash]bool
+	has    map[RecordHash]int // Index into Values
 }
 
 func NewRecordSet(capacity int) RecordSet {
 	var ret RecordSet
 	ret.Values = make([]Record, 0, capacity)
-	ret.has = make(map[RecordHash]bool, capacity)
+	ret.has = make(map[RecordHash]int, capacity)
 	return ret
 }
 
 // Returns true if inserted, false if already in set
 func (s *RecordSet) Insert(r *Record) bool {
 	hash := r.Hash()
-	if !s.has[hash] {
-		s.has[hash] = true
+	if _, exists := s.has[hash]; !exists {
+		s.has[hash] = len(s.Values)
 		s.Values = append(s.Values, *r)
 		return true
 	} else {
 		return false
 	}
 }
+
+// Returns the record in the set with the specified hash, if present
+func (s *RecordSet) Get(hash RecordHash) (*Record, bool) {
+	if i, exists := s.has[hash]; exists {
+		return &s.Values[i], true
+	}
+	return nil, false
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Minimal SQLite database access, via the sqlite3 command-line tool
+ */
+package reid
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Separators used by the sqlite3 tool's "ascii" output mode
+const (
+	sqliteColSep = "\x1f"
+	sqliteRowSep = "\x1e"
+)
+
+// Run a query against a database (opened read-only) and return the resulting
+// rows as lists of column values. NULL values are returned as empty strings.
+func sqliteQuery(dbFile, query string) ([][]string, error) {
+	var rows [][]string
+
+	// sqlite3 will happily create an empty database if the file is missing
+	if _, err := os.Stat(dbFile); err != nil {
+		return rows, err
+	}
+
+	Verbosef("Running query on %s: %s\n", dbFile, query)
+	output, err := exec.Command("sqlite3", "-readonly", "-ascii", "-noheader", dbFile, query).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) != 0 {
+			return rows, fmt.Errorf("sqlite3 query failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return rows, err
+	}
+
+	for _, row := range strings.Split(string(output), sqliteRowSep) {
+		if len(row) != 0 {
+			rows = append(rows, strings.Split(row, sqliteColSep))
+		}
+	}
+
+	return rows, nil
+}
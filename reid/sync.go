@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Incremental project updates from a new export of a library
+ */
+
+package reid
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+)
+
+// Summary of the changes made by Project.Sync()
+type SyncSummary struct {
+	Added     []Record // Records not previously in the project
+	Updated   []Record // Records whose metadata or PDFs changed
+	Removed   []Record // Entries no longer present in the library
+	Unchanged int      // Number of entries that were not changed
+}
+
+func writeSyncRecords(b *bytes.Buffer, what string, records []Record, eol string) {
+	fmt.Fprintf(b, "%s: %d%s", what, len(records), eol)
+	for i := range records {
+		fmt.Fprintf(b, "   %s%s", records[i].String(), eol)
+	}
+}
+
+func (s SyncSummary) Pretty(eol string) string {
+	var b bytes.Buffer
+
+	writeSyncRecords(&b, "Added", s.Added, eol)
+	writeSyncRecords(&b, "Updated", s.Updated, eol)
+	writeSyncRecords(&b, "Removed", s.Removed, eol)
+	fmt.Fprintf(&b, "Unchanged: %d%s", s.Unchanged, eol)
+
+	return b.String()
+}
+
+// PDFs are compared by the portion of their path that determines the name of
+// their minified text file (see convertPDF), as the paths stored in a project
+// may have been translated (see findPDF).
+func pdfKey(pdf string) string {
+	pdf = normalizeSeparators(pdf)
+	return filepath.Join(filepath.Base(filepath.Dir(pdf)), filepath.Base(pdf))
+}
+
+func samePDFs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if pdfKey(a[i]) != pdfKey(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns a copy of the record suitable for comparing metadata
+func normalizedRecord(r Record) Record {
+	for _, field := range []*[]string{&r.URLs, &r.AltPublications, &r.Authors, &r.Keywords, &r.Status} {
+		if len(*field) == 0 {
+			*field = nil
+		}
+	}
+	r.PDFs = nil
+	return r
+}
+
+func sameMetadata(a, b Record) bool {
+	return reflect.DeepEqual(normalizedRecord(a), normalizedRecord(b))
+}
+
+// Locates the entries corresponding to records from a library. Entries are
+// matched by identifier (including former identifiers), or by metadata hash
+// for records whose identity scheme has changed (e.g., a DOI was added).
+type syncIndex struct {
+	byID    map[string]int
+	byHash  map[string][]int
+	matched map[int]bool
+}
+
+func newSyncIndex(entries []ProjectEntry) syncIndex {
+	idx := syncIndex{
+		byID:    make(map[string]int, len(entries)),
+		byHash:  make(map[string][]int, len(entries)),
+		matched: make(map[int]bool, len(entries)),
+	}
+
+	for i := range entries {
+		for _, prev := range entries[i].PrevHashes {
+			idx.byID[prev] = i
+		}
+
+		hash := entries[i].Record.HashString()
+		idx.byHash[hash] = append(idx.byHash[hash], i)
+	}
+
+	// Current identifiers take precedence over former ones
+	for i := range entries {
+		idx.byID[entries[i].Hash] = i
+	}
+
+	return idx
+}
+
+// Returns the index of the matching entry, or -1 if there is none
+func (idx syncIndex) match(r *Record) int {
+	if i, found := idx.byID[r.IdentityString()]; found && !idx.matched[i] {
+		idx.matched[i] = true
+		return i
+	}
+
+	for _, i := range idx.byHash[r.HashString()] {
+		if !idx.matched[i] {
+			idx.matched[i] = true
+			return i
+		}
+	}
+
+	return -1
+}
+
+/*
+ * Update the project from a new export of its library:
+ *	- Records not in the project are added.
+ *	- Entries whose metadata or PDFs changed are updated. If an entry's PDFs
+ *	  changed, it must be converted again. Existing minified text files are
+ *	  reused by the conversion, unless it is forced.
+ *	- Entries no longer in the library are marked with StatusRemoved, and
+ *	  are no longer converted or searched. These are unmarked if they
+ *	  reappear in a later export.
+ *	- Entries merged from other projects (see Project.Merge) are retained
+ *	  as-is, unless the library now contains their records, in which case
+ *	  they are updated like any other entry.
+ *
+ * The conversion state of all other entries is retained. The project file is
+ * saved upon success.
+ */
+func (p *Project) Sync(records []Record) (SyncSummary, error) {
+	var summary SyncSummary
+	var added []Record
+
+	idx := newSyncIndex(p.Entries)
+
+	for _, record := range records {
+		i := idx.match(&record)
+		if i < 0 {
+			added = append(added, record)
+			continue
+		}
+		e := &p.Entries[i]
+
+		// An entry merged from another project is now part of this library
+		e.MergedFrom = ""
+
+		pdfsChanged := !samePDFs(e.Record.PDFs, record.PDFs)
+		if !pdfsChanged && sameMetadata(e.Record, record) {
+			summary.Unchanged++
+			continue
+		}
+
+		Verbosef("Updating entry: %s\n", record.String())
+
+		if pdfsChanged {
+			e.clearConversion()
+		} else {
+			// Retain the paths at which the PDFs were located
+			record.PDFs = e.Record.PDFs
+		}
+
+		e.Record = record
+		p.updateIdentity(e)
+		summary.Updated = append(summary.Updated, record)
+	}
+
+	for i := range p.Entries {
+		e := &p.Entries[i]
+		if idx.matched[i] || e.Record.isRemoved() || len(e.MergedFrom) != 0 {
+			continue
+		}
+
+		Verbosef("Marking entry as removed: %s\n", e.Record.String())
+		e.Record.Status = append(e.Record.Status, StatusRemoved)
+		summary.Removed = append(summary.Removed, e.Record)
+	}
+
+	for _, record := range added {
+		Verbosef("Adding entry: %s\n", record.String())
+		entry := ProjectEntry{Record: record, MiniFiles: []string{}}
+		entry.setIdentity(func(id string) bool {
+			_, used := idx.byID[id]
+			return used
+		})
+		idx.byID[entry.Hash] = len(p.Entries)
+
+		p.Entries = append(p.Entries, entry)
+		summary.Added = append(summary.Added, record)
+	}
+
+	if _, err := p.scan(); err != nil {
+		return summary, err
+	}
+
+	Verbosef("Saving project file: %s\n", p.filename)
+	return summary, p.Save(p.filename)
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Tests of incremental project updates
+ */
+
+package reid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Records of the library from which sync test projects are created
+func syncLibrary() []Record {
+	a := testRecord("A", 1, "")
+	a.PDFs = []string{"/library/PDF/1/a.pdf"}
+
+	b := testRecord("B", 0, "")
+	b.PDFs = []string{"/library/PDF/2/b.pdf"}
+
+	c := testRecord("C", 3, "")
+	c.PDFs = []string{"/library/PDF/3/c.pdf"}
+
+	return []Record{a, b, c}
+}
+
+/*
+ * Create a project from syncLibrary(), in which all entries are converted,
+ * along with an entry merged from another project. The merged entry's record
+ * number is that of "A", in the other project's library.
+ */
+func newSyncProject(t *testing.T, dir string) *Project {
+	p, err := NewProject(filepath.Join(dir, "data"), syncLibrary())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := ProjectEntry{Record: testRecord("M", 1, "10.1000/m"), MergedFrom: "other.json"}
+	merged.setIdentity(func(id string) bool { return false })
+	p.Entries = append(p.Entries, merged)
+
+	for i := range p.Entries {
+		p.Entries[i].MiniFiles = []string{filepath.Join(p.DataDir, p.Entries[i].Record.Title+".txt")}
+	}
+
+	p.filename = filepath.Join(dir, "project.json")
+	if err = p.Save(p.filename); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func syncTitles(records []Record) []string {
+	var titles []string
+	for i := range records {
+		titles = append(titles, records[i].Title)
+	}
+	return titles
+}
+
+var syncTests = []struct {
+	name string
+
+	// Modify the export of the library
+	export func(records []Record) []Record
+
+	added     []string // Titles of records expected to be added
+	updated   []string
+	removed   []string
+	unchanged int
+
+	converted []string // Titles of entries expected to retain their minified text
+	schemes   []string // Expected identity schemes of the entries, in order
+}{
+	{
+		name:      "unchanged",
+		export:    func(r []Record) []Record { return r },
+		unchanged: 3,
+		converted: []string{"A", "B", "C", "M"},
+		schemes:   []string{IDSchemeRecNumber, IDSchemeHash, IDSchemeRecNumber, IDSchemeDOI},
+	},
+	{
+		name: "PDFs relocated",
+		export: func(r []Record) []Record {
+			r[0].PDFs = []string{"/mnt/library/PDF/1/a.pdf"}
+			return r
+		},
+		unchanged: 3,
+		converted: []string{"A", "B", "C", "M"},
+		schemes:   []string{IDSchemeRecNumber, IDSchemeHash, IDSchemeRecNumber, IDSchemeDOI},
+	},
+	{
+		name: "metadata corrected",
+		export: func(r []Record) []Record {
+			r[0].Title = "A (corrected)"
+			return r
+		},
+		updated:   []string{"A (corrected)"},
+		unchanged: 2,
+		converted: []string{"A (corrected)", "B", "C", "M"},
+		schemes:   []string{IDSchemeRecNumber, IDSchemeHash, IDSchemeRecNumber, IDSchemeDOI},
+	},
+	{
+		name: "PDF replaced",
+		export: func(r []Record) []Record {
+			r[0].PDFs = []string{"/library/PDF/1/a-annotated.pdf"}
+			return r
+		},
+		updated:   []string{"A"},
+		unchanged: 2,
+		converted: []string{"B", "C", "M"},
+		schemes:   []string{IDSchemeRecNumber, IDSchemeHash, IDSchemeRecNumber, IDSchemeDOI},
+	},
+	{
+		name: "DOI added",
+		export: func(r []Record) []Record {
+			r[1].DOI = "10.1000/b"
+			return r
+		},
+		updated:   []string{"B"},
+		unchanged: 2,
+		converted: []string{"A", "B", "C", "M"},
+		schemes:   []string{IDSchemeRecNumber, IDSchemeDOI, IDSchemeRecNumber, IDSchemeDOI},
+	},
+	{
+		name: "removed",
+		export: func(r []Record) []Record {
+			return r[:2]
+		},
+		removed:   []string{"C"},
+		unchanged: 2,
+		converted: []string{"A", "B", "C", "M"},
+		schemes:   []string{IDSchemeRecNumber, IDSchemeHash, IDSchemeRecNumber, IDSchemeDOI},
+	},
+	{
+		name: "added",
+		export: func(r []Record) []Record {
+			d := testRecord("D", 4, "10.1000/m") // DOI of the merged entry
+			d.PDFs = []string{"/library/PDF/4/d.pdf"}
+			return append(r, d)
+		},
+		added:     []string{"D"},
+		unchanged: 3,
+		converted: []string{"A", "B", "C", "M"},
+		schemes:   []string{IDSchemeRecNumber, IDSchemeHash, IDSchemeRecNumber, IDSchemeDOI, IDSchemeRecNumber},
+	},
+	{
+		name: "merged record added to library",
+		export: func(r []Record) []Record {
+			m := testRecord("M", 9, "10.1000/m")
+			return append(r, m)
+		},
+		updated:   []string{"M"},
+		unchanged: 3,
+		converted: []string{"A", "B", "C", "M"},
+		schemes:   []string{IDSchemeRecNumber, IDSchemeHash, IDSchemeRecNumber, IDSchemeRecNumber},
+	},
+}
+
+func TestSync(t *testing.T) {
+	for _, test := range syncTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reid-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			p := newSyncProject(t, dir)
+			original := make([]ProjectEntry, len(p.Entries))
+			copy(original, p.Entries)
+
+			summary, err := p.Sync(test.export(syncLibrary()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results := []struct {
+				what   string
+				got    []string
+				expect []string
+			}{
+				{"Added", syncTitles(summary.Added), test.added},
+				{"Updated", syncTitles(summary.Updated), test.updated},
+				{"Removed", syncTitles(summary.Removed), test.removed},
+			}
+
+			for _, r := range results {
+				if !reflect.DeepEqual(r.got, r.expect) {
+					t.Errorf("%s: got %v, expected %v", r.what, r.got, r.expect)
+				}
+			}
+
+			if summary.Unchanged != test.unchanged {
+				t.Errorf("Unchanged: got %d, expected %d", summary.Unchanged, test.unchanged)
+			}
+
+			var converted, schemes []string
+			for i, e := range p.Entries {
+				if len(e.MiniFiles) != 0 {
+					converted = append(converted, e.Record.Title)
+				}
+				schemes = append(schemes, e.IDScheme)
+
+				// Matched entries retain the paths at which their PDFs were located
+				if i < len(original) && samePDFs(e.Record.PDFs, original[i].Record.PDFs) &&
+					!reflect.DeepEqual(e.Record.PDFs, original[i].Record.PDFs) {
+					t.Errorf("PDF paths of %s were replaced: %v", e.Record.Title, e.Record.PDFs)
+				}
+
+				// Former identifiers may still be used
+				if i < len(original) && e.Hash != original[i].Hash {
+					if !reflect.DeepEqual(e.PrevHashes, []string{original[i].Hash}) {
+						t.Errorf("Previous identifiers of %s: got %v", e.Record.Title, e.PrevHashes)
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(converted, test.converted) {
+				t.Errorf("Converted: got %v, expected %v", converted, test.converted)
+			}
+
+			if !reflect.DeepEqual(schemes, test.schemes) {
+				t.Errorf("Schemes: got %v, expected %v", schemes, test.schemes)
+			}
+
+			// Syncing the same export again changes nothing
+			summary, err = p.Sync(test.export(syncLibrary()))
+			if err != nil {
+				t.Fatal(err)
+			} else if len(summary.Added)+len(summary.Updated)+len(summary.Removed) != 0 {
+				t.Errorf("Second sync was not a no-op:\n%s", summary.Pretty("\n"))
+			}
+		})
+	}
+}
+
+// Removed entries are restored when their records reappear in the library
+func TestSyncRestoresRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := newSyncProject(t, dir)
+	library := syncLibrary()
+
+	if _, err = p.Sync(library[:2]); err != nil {
+		t.Fatal(err)
+	} else if !p.Entries[2].Record.isRemoved() {
+		t.Fatal("Entry was not marked as removed")
+	}
+
+	summary, err := p.Sync(library)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(syncTitles(summary.Updated), []string{"C"}) || len(summary.Added) != 0 {
+		t.Errorf("Unexpected changes:\n%s", summary.Pretty("\n"))
+	}
+
+	if p.Entries[2].Record.isRemoved() || len(p.Entries[2].MiniFiles) == 0 {
+		t.Error("Entry was not restored, along with its minified text")
+	}
+}
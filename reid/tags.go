@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * User-defined tags and notes on project entries
+ *
+ * Tags name ad-hoc collections of entries (e.g., "core corpus" or
+ * "reviewer-2 batch"), which may be used to limit the entries that are
+ * converted or searched. Tags are compared case-insensitively.
+ */
+
+package reid
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Limits operations to entries with (or without) specific tags
+type TagFilter struct {
+	Include []string // Entries must have at least one of these, if any
+	Exclude []string // Entries must have none of these
+}
+
+func (e *ProjectEntry) HasTag(tag string) bool {
+	tag = strings.TrimSpace(tag)
+	for _, t := range e.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a tag to the entry. Returns false if it already has it.
+func (e *ProjectEntry) addTag(tag string) bool {
+	if e.HasTag(tag) {
+		return false
+	}
+	e.Tags = append(e.Tags, strings.TrimSpace(tag))
+	return true
+}
+
+// Remove a tag from the entry. Returns false if it did not have it.
+func (e *ProjectEntry) removeTag(tag string) bool {
+	tag = strings.TrimSpace(tag)
+	for i, t := range e.Tags {
+		if strings.EqualFold(t, tag) {
+			e.Tags = append(e.Tags[:i], e.Tags[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether the entry has none of the excluded tags, and any of the
+// included tags (if there are any)
+func (f TagFilter) Matches(e *ProjectEntry) bool {
+	for _, tag := range f.Exclude {
+		if e.HasTag(tag) {
+			return false
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+
+	for _, tag := range f.Include {
+		if e.HasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f TagFilter) filter(entries pEntryList) pEntryList {
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return entries
+	}
+
+	var matched pEntryList
+	for _, e := range entries {
+		if f.Matches(e) {
+			matched = append(matched, e)
+		} else {
+			Debugf("Excluded by tag filter: %s\n", e.Record.String())
+		}
+	}
+	return matched
+}
+
+// Limit the entries converted or enriched to those matching the filter
+func (p *Project) RestrictToTags(filter TagFilter) {
+	p.tagFilter = filter
+}
+
+func validTag(tag string) error {
+	if len(strings.TrimSpace(tag)) == 0 {
+		return fmt.Errorf("Tags may not be empty")
+	}
+	return nil
+}
+
+// Add a tag to the specified entries. Returns the number of entries that
+// did not already have it. The project is not saved.
+func (p *Project) TagEntries(tag string, entries []*ProjectEntry) (int, error) {
+	var n int
+
+	if err := validTag(tag); err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		if e.addTag(tag) {
+			Verbosef("Tagged \"%s\": %s\n", tag, e.Record.String())
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Remove a tag from the specified entries. Returns the number of entries
+// that had it. The project is not saved.
+func (p *Project) UntagEntries(tag string, entries []*ProjectEntry) (int, error) {
+	var n int
+
+	if err := validTag(tag); err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		if e.removeTag(tag) {
+			Verbosef("Untagged \"%s\": %s\n", tag, e.Record.String())
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Returns the entries with the specified identifiers (see FindEntry)
+func (p *Project) EntriesByID(ids []string) ([]*ProjectEntry, error) {
+	entries := make([]*ProjectEntry, 0, len(ids))
+	for _, id := range ids {
+		i, err := p.FindEntry(id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &p.Entries[i])
+	}
+	return entries, nil
+}
+
+// Set (or, if empty, clear) an entry's notes. The project is not saved.
+func (p *Project) SetNotes(id, notes string) (*ProjectEntry, error) {
+	i, err := p.FindEntry(id)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Entries[i].Notes = strings.TrimSpace(notes)
+	return &p.Entries[i], nil
+}
+
+// A tag, and the number of entries that have it
+type TagCount struct {
+	Tag     string
+	Entries int
+}
+
+type byTag []TagCount
+
+func (t byTag) Len() int           { return len(t) }
+func (t byTag) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byTag) Less(i, j int) bool { return strings.ToLower(t[i].Tag) < strings.ToLower(t[j].Tag) }
+
+// Returns the tags used in the project, in alphabetical order
+func (p *Project) Tags() []TagCount {
+	var tags []TagCount
+	index := make(map[string]int)
+
+	for _, e := range p.Entries {
+		for _, tag := range e.Tags {
+			key := strings.ToLower(tag)
+			if i, found := index[key]; found {
+				tags[i].Entries++
+			} else {
+				index[key] = len(tags)
+				tags = append(tags, TagCount{Tag: tag, Entries: 1})
+			}
+		}
+	}
+
+	sort.Sort(byTag(tags))
+	return tags
+}
+
+func PrettyTags(tags []TagCount, eol string) string {
+	var b bytes.Buffer
+	for _, t := range tags {
+		fmt.Fprintf(&b, "%6d  %s%s", t.Entries, t.Tag, eol)
+	}
+	return b.String()
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright (c) 2017-2018 Jon Szymaniak <jon.szymaniak@gmail.com>
+ * SPDX License Identifier: GPL-3.0
+ *
+ * Load records directly from a Zotero (5.x) zotero.sqlite database.
+ *
+ * Zotero holds a lock on its database while running, so this is intended to
+ * be used with a copy of zotero.sqlite, alongside (or with access to) the
+ * original storage/ directory.
+ */
+package reid
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Attachment link modes, as defined by Zotero
+const (
+	zoteroLinkImportedFile = 0
+	zoteroLinkImportedURL  = 1
+	zoteroLinkLinkedFile   = 2
+)
+
+// Fields checked (in order) for the publication name
+var zoteroPubFields = []string{
+	"publicationTitle", "proceedingsTitle", "bookTitle", "conferenceName",
+}
+
+const zoteroItemQuery = `
+SELECT i.itemID, f.fieldName, v.value
+FROM items i
+JOIN itemTypes t ON t.itemTypeID = i.itemTypeID
+JOIN itemData d ON d.itemID = i.itemID
+JOIN fields f ON f.fieldID = d.fieldID
+JOIN itemDataValues v ON v.valueID = d.valueID
+WHERE t.typeName NOT IN ('attachment', 'note', 'annotation')
+AND i.itemID NOT IN (SELECT itemID FROM deletedItems)
+AND f.fieldName IN ('title', 'date', 'language', 'publicationTitle',
+                    'proceedingsTitle', 'bookTitle', 'conferenceName',
+                    'journalAbbreviation', 'DOI', 'abstractNote', 'volume', 'issue',
+                    'pages', 'url')
+UNION ALL
+SELECT i.itemID, 'itemType', t.typeName
+FROM items i
+JOIN itemTypes t ON t.itemTypeID = i.itemTypeID
+WHERE t.typeName NOT IN ('attachment', 'note', 'annotation')
+AND i.itemID NOT IN (SELECT itemID FROM deletedItems)
+ORDER BY 1;`
+
+const zoteroCreatorQuery = `
+SELECT ic.itemID, c.lastName, c.firstName
+FROM itemCreators ic
+JOIN creators c ON c.creatorID = ic.creatorID
+JOIN creatorTypes ct ON ct.creatorTypeID = ic.creatorTypeID
+WHERE ct.creatorType = 'author'
+ORDER BY ic.itemID, ic.orderIndex;`
+
+const zoteroAttachmentQuery = `
+SELECT a.parentItemID, i.key, a.linkMode, a.path
+FROM itemAttachments a
+JOIN items i ON i.itemID = a.itemID
+WHERE a.parentItemID IS NOT NULL
+AND a.contentType = 'application/pdf'
+AND a.itemID NOT IN (SELECT itemID FROM deletedItems)
+ORDER BY a.parentItemID, a.itemID;`
+
+type zoteroLoader struct {
+	dbFile     string
+	storageDir string // Zotero's storage/ directory
+	linkedDir  string // Base directory for relative linked attachments
+	pathMap    PathMap
+
+	keepIncomplete bool
+
+	items map[string]*zoteroItem // Keyed on itemID
+	order []string               // itemIDs, in database order
+}
+
+type zoteroItem struct {
+	fields  map[string]string
+	authors []string
+	pdfs    []string
+}
+
+func (l *zoteroLoader) item(id string) *zoteroItem {
+	item, exists := l.items[id]
+	if !exists {
+		item = &zoteroItem{fields: make(map[string]string)}
+		l.items[id] = item
+		l.order = append(l.order, id)
+	}
+	return item
+}
+
+func (l *zoteroLoader) loadFields() error {
+	rows, err := sqliteQuery(l.dbFile, zoteroItemQuery)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if len(row) != 3 {
+			Debugf("Ignoring malformed item data row: %q\n", row)
+			continue
+		}
+		l.item(row[0]).fields[row[1]] = row[2]
+	}
+
+	return nil
+}
+
+func (l *zoteroLoader) loadCreators() error {
+	rows, err := sqliteQuery(l.dbFile, zoteroCreatorQuery)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if len(row) != 3 {
+			Debugf("Ignoring malformed creator row: %q\n", row)
+			continue
+		}
+
+		item, exists := l.items[row[0]]
+		if !exists {
+			continue // Deleted, or not an item we care about
+		}
+
+		// Single-field names (e.g., institutions) have an empty first name
+		name := row[1]
+		if len(row[2]) != 0 {
+			name += ", " + row[2]
+		}
+		item.authors = append(item.authors, name)
+	}
+
+	return nil
+}
+
+// Convert an attachment's path column to a local path.
+//
+// Files stored by Zotero are listed as "storage:<filename>" and live in
+// storage/<attachment key>/<filename>. Linked files are either absolute paths
+// or "attachments:<path>", relative to the (user-configured) linked attachment
+// base directory.
+func (l *zoteroLoader) attachmentPath(key string, linkMode int, path string) (string, bool) {
+	switch linkMode {
+	case zoteroLinkImportedFile, zoteroLinkImportedURL:
+		if !strings.HasPrefix(path, "storage:") {
+			return "", false
+		}
+		return filepath.Join(l.storageDir, key, strings.TrimPrefix(path, "storage:")), true
+
+	case zoteroLinkLinkedFile:
+		if strings.HasPrefix(path, "attachments:") {
+			if len(l.linkedDir) == 0 {
+				Debugf("No linked attachment directory specified for: %s\n", path)
+				return "", false
+			}
+			return filepath.Join(l.linkedDir, strings.TrimPrefix(path, "attachments:")), true
+		}
+		return localFilePath(path, "", l.pathMap)
+
+	default:
+		return "", false
+	}
+}
+
+func (l *zoteroLoader) loadAttachments() error {
+	rows, err := sqliteQuery(l.dbFile, zoteroAttachmentQuery)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if len(row) != 4 {
+			Debugf("Ignoring malformed attachment row: %q\n", row)
+			continue
+		}
+
+		item, exists := l.items[row[0]]
+		if !exists {
+			continue
+		}
+
+		linkMode, err := strconv.Atoi(row[2])
+		if err != nil {
+			Debugf("Invalid link mode for attachment %s: %s\n", row[1], row[2])
+			continue
+		}
+
+		if pdf, local := l.attachmentPath(row[1], linkMode, row[3]); local {
+			item.pdfs = append(item.pdfs, pdf)
+		} else {
+			Debugf("Ignoring attachment %s (link mode %d): %s\n", row[1], linkMode, row[3])
+		}
+	}
+
+	return nil
+}
+
+func (l *zoteroLoader) loadRecord(id string, item *zoteroItem) *Record {
+	var rec Record
+
+	// Item IDs are stable within a library, and serve as record numbers
+	rec.RecNumber, _ = strconv.Atoi(id)
+	rec.Title = item.fields["title"]
+	rec.Language = item.fields["language"]
+	rec.Authors = item.authors
+	rec.PDFs = item.pdfs
+	rec.RefType = item.fields["itemType"]
+	rec.DOI = normalizeDOI(item.fields["DOI"])
+	rec.Abstract = item.fields["abstractNote"]
+	rec.Volume = item.fields["volume"]
+	rec.Issue = item.fields["issue"]
+	rec.Pages = item.fields["pages"]
+
+	if url := item.fields["url"]; len(url) != 0 {
+		rec.URLs = []string{url}
+	}
+
+	for _, field := range zoteroPubFields {
+		if pub := item.fields[field]; len(pub) != 0 {
+			rec.Publication = pub
+			break
+		}
+	}
+	rec.addAltPublication(item.fields["journalAbbreviation"])
+
+	// Dates are stored as "YYYY-MM-DD <original text>", with zeros for unknowns
+	if year := reYear.FindString(item.fields["date"]); len(year) != 0 {
+		rec.Year, _ = strconv.Atoi(year)
+	}
+
+	if accept, _ := acceptRecord(&rec, l.keepIncomplete); !accept {
+		return nil
+	}
+
+	return &rec
+}
+
+/*
+ * Load records from a zotero.sqlite database. The storage/ directory is
+ * expected to reside alongside the database file. config.PDFDir specifies the
+ * base directory for linked attachments with relative paths, and may be
+ * empty if not applicable.
+ */
+func LoadRecordsFromZotero(filename string, config LoadConfig) ([]Record, error) {
+	var records RecordSet = NewRecordSet(recSetCapacity)
+	var err error
+
+	l := zoteroLoader{
+		dbFile:    filename,
+		linkedDir: config.PDFDir,
+		pathMap:   config.PathMap,
+
+		keepIncomplete: config.KeepIncomplete,
+		items:          make(map[string]*zoteroItem),
+	}
+
+	if l.storageDir, err = filepath.Abs(filepath.Join(filepath.Dir(filename), "storage")); err != nil {
+		return []Record{}, err
+	}
+
+	if len(l.linkedDir) != 0 {
+		if l.linkedDir, err = filepath.Abs(l.linkedDir); err != nil {
+			return []Record{}, err
+		}
+	}
+
+	Verbose("Loading item fields")
+	if err = l.loadFields(); err != nil {
+		return []Record{}, err
+	}
+
+	Verbose("Loading item creators")
+	if err = l.loadCreators(); err != nil {
+		return []Record{}, err
+	}
+
+	Verbose("Loading item attachments")
+	if err = l.loadAttachments(); err != nil {
+		return []Record{}, err
+	}
+
+	lowerLangs(config.Languages)
+
+	for _, id := range l.order {
+		if rec := l.loadRecord(id, l.items[id]); rec != nil {
+			insertRecord(&records, rec, config.Languages)
+		}
+	}
+
+	return records.Values, nil
+}